@@ -21,9 +21,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/openebs/cstor-csi/pkg/config"
 	"github.com/openebs/cstor-csi/pkg/driver"
+	"github.com/openebs/cstor-csi/pkg/utils"
 	"github.com/openebs/cstor-csi/pkg/version"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -67,6 +69,31 @@ func main() {
 		&config.PluginType, "plugin", "csi-plugin", "Type of this driver i.e. controller or node",
 	)
 
+	cmd.PersistentFlags().DurationVar(
+		&config.ISCSISessionMonitorInterval, "iscsi-session-monitor-interval", 30*time.Second,
+		"Interval at which the node plugin verifies and recovers iSCSI sessions, 0 disables it",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ISCSIHostInterface, "iscsi-host-interface", "",
+		"Host network interface or CIDR to which the iSCSI logins of the node are bound",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ISCSISessionGC, "iscsi-session-gc", "enabled",
+		"Logout of stale iSCSI sessions on startup: enabled, dry-run or disabled",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.StateDir, "state-dir", "/plugin/state",
+		"Directory in which the node plugin journals the attach state of its volumes, empty disables it",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.MaxConcurrentTrims, "max-concurrent-trims", 1,
+		"Number of volumes the node plugin trims at once as set by their trim schedule, 0 disables it",
+	)
+
 	err := cmd.Execute()
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "%s", err.Error())
@@ -75,6 +102,9 @@ func main() {
 }
 
 func run(config *config.Config) {
+	if err := utils.VerifyEnv(); err != nil {
+		log.Fatalln(err)
+	}
 	if config.Version == "" {
 		config.Version = version.Current()
 	}
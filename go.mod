@@ -5,7 +5,6 @@ go 1.19
 require (
 	github.com/container-storage-interface/spec v1.8.0
 	github.com/google/uuid v1.3.1
-	github.com/kubernetes-csi/csi-lib-iscsi v0.0.0-20200118015005-959f12c91ca8
 	github.com/kubernetes-csi/csi-lib-utils v0.14.0
 	github.com/onsi/ginkgo v1.16.4
 	github.com/onsi/gomega v1.27.7
@@ -90,7 +89,7 @@ require (
 )
 
 replace (
-	github.com/container-storage-interface/spec => github.com/container-storage-interface/spec v1.4.0
+	github.com/container-storage-interface/spec => github.com/container-storage-interface/spec v1.8.0
 	k8s.io/csi-translation-lib => k8s.io/csi-translation-lib v0.27.2
 	k8s.io/kubernetes => k8s.io/kubernetes v1.27.2
 	k8s.io/mount-utils => k8s.io/mount-utils v0.27.2
@@ -16,6 +16,8 @@ limitations under the License.
 
 package config
 
+import "time"
+
 // Config struct fills the parameters of request or user input
 type Config struct {
 	// DriverName to be registered at CSI
@@ -46,6 +48,31 @@ type Config struct {
 	// A REST Server is exposed on this URL for internal
 	// operations and Day-2 ops
 	RestURL string
+
+	// ISCSISessionMonitorInterval is the interval at which the node plugin
+	// verifies the health of the iSCSI sessions of the volumes staged on
+	// the node. Monitoring is disabled if it is set to zero.
+	ISCSISessionMonitorInterval time.Duration
+
+	// ISCSIHostInterface is the host network interface, or a CIDR one of
+	// its addresses belongs to, through which the node logs in to the
+	// iSCSI targets. The logins use the default route if it is not set.
+	ISCSIHostInterface string
+
+	// ISCSISessionGC controls whether the iSCSI sessions to cStor targets
+	// which are not referred to by any CVA of the node are logged out on
+	// startup. It is one of enabled, dry-run or disabled.
+	ISCSISessionGC string
+
+	// StateDir is the directory in which the node plugin journals the
+	// attach state of the volumes staged on the node. Journaling is
+	// disabled if it is not set.
+	StateDir string
+
+	// MaxConcurrentTrims is the number of volumes whose filesystem is
+	// trimmed at once by the trim scheduler of the node plugin. The
+	// scheduler is disabled if it is set to zero.
+	MaxConcurrentTrims int
 }
 
 // Default returns a new instance of config
@@ -186,6 +186,16 @@ func (b *Builder) WithMountOptionsNew(mountOptions []string) *Builder {
 	return b
 }
 
+// WithAccessModes sets the access modes the csi volume is attached with
+func (b *Builder) WithAccessModes(accessModes []string) *Builder {
+	//Error is not being retured over here since this is an optional field
+	if len(accessModes) == 0 {
+		return b
+	}
+	b.volume.Object.Spec.Volume.AccessModes = append([]string{}, accessModes...)
+	return b
+}
+
 // WithDevicePath sets the devicePath of csi volume
 func (b *Builder) WithDevicePath(devicePath string) *Builder {
 	if devicePath == "" {
@@ -329,6 +339,43 @@ func (b *Builder) WithLabelsNew(labels map[string]string) *Builder {
 	return b
 }
 
+// WithAnnotations merges existing annotations of csi volume if any
+// with the ones that are provided here
+func (b *Builder) WithAnnotations(annotations map[string]string) *Builder {
+	//Error is not being retured over here since this is an optional field
+	if len(annotations) == 0 {
+		return b
+	}
+
+	if b.volume.Object.Annotations == nil {
+		return b.WithAnnotationsNew(annotations)
+	}
+
+	for key, value := range annotations {
+		b.volume.Object.Annotations[key] = value
+	}
+	return b
+}
+
+// WithAnnotationsNew resets existing annotations of csi volume if any with
+// ones that are provided here
+func (b *Builder) WithAnnotationsNew(annotations map[string]string) *Builder {
+	//Error is not being retured over here since this is an optional field
+	if len(annotations) == 0 {
+		return b
+	}
+
+	// copy of original map
+	newannotations := map[string]string{}
+	for key, value := range annotations {
+		newannotations[key] = value
+	}
+
+	// override
+	b.volume.Object.Annotations = newannotations
+	return b
+}
+
 // Build returns csi volume API object
 func (b *Builder) Build() (*apis.CStorVolumeAttachment, error) {
 	if len(b.errs) > 0 {
@@ -116,6 +116,31 @@ func (cs *controller) CreateVolume(
 	VolumeContext := map[string]string{
 		"openebs.io/cas-type": req.GetParameters()["cas-type"],
 	}
+	// iSCSI session tunables are applied by the node during login, they
+	// are passed on to it through the volume context
+	for param := range iscsiSessionParamKeys {
+		if value, ok := req.GetParameters()[param]; ok {
+			VolumeContext[param] = value
+		}
+	}
+	if hostIface := req.GetParameters()[iscsiHostInterfaceKey]; hostIface != "" {
+		VolumeContext[iscsiHostInterfaceKey] = hostIface
+	}
+	if encrypted := req.GetParameters()[encryptedKey]; encrypted != "" {
+		VolumeContext[encryptedKey] = encrypted
+	}
+	if mkfsOptions := req.GetParameters()[mkfsOptionsKey]; mkfsOptions != "" {
+		VolumeContext[mkfsOptionsKey] = mkfsOptions
+	}
+	if adopt := req.GetParameters()[adoptExistingFilesystemKey]; adopt != "" {
+		VolumeContext[adoptExistingFilesystemKey] = adopt
+	}
+	if policy := req.GetParameters()[fsckPolicyKey]; policy != "" {
+		VolumeContext[fsckPolicyKey] = policy
+	}
+	if schedule := req.GetParameters()[trimScheduleKey]; schedule != "" {
+		VolumeContext[trimScheduleKey] = schedule
+	}
 	pvcName := req.GetParameters()[pvcNameKey]
 	pvcNamespace := req.GetParameters()[pvcNamespaceKey]
 
@@ -145,7 +170,7 @@ func (cs *controller) CreateVolume(
 
 	err = utils.ProvisionVolume(size, volName, rCount,
 		cspcName, snapshotID,
-		nodeID, policyName, pvcName, pvcNamespace)
+		nodeID, policyName, pvcName, pvcNamespace, nil)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -185,9 +210,10 @@ func (cs *controller) DeleteVolume(
 
 	volumeID := req.GetVolumeId()
 
-	// verify if the volume has already been deleted
+	// verify if the volume has already been deleted, statically
+	// provisioned volumes have no CVC and are left as they are
 	cvc, err = utils.GetVolume(volumeID)
-	if cvc != nil && cvc.DeletionTimestamp != nil {
+	if k8serror.IsNotFound(err) || (cvc != nil && cvc.DeletionTimestamp != nil) {
 		goto deleteResponse
 	}
 
@@ -18,8 +18,13 @@ package driver
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -31,6 +36,15 @@ var SupportedVolumeCapabilityAccessModes = []*csi.VolumeCapability_AccessMode{
 	&csi.VolumeCapability_AccessMode{
 		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
 	},
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+	},
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+	},
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+	},
 }
 
 // newControllerCapabilities returns a list
@@ -56,6 +70,7 @@ func newControllerCapabilities() []*csi.ControllerServiceCapability {
 		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
 		csi.ControllerServiceCapability_RPC_GET_VOLUME,
 		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 	} {
 		capabilities = append(capabilities, fromType(cap))
 	}
@@ -144,6 +159,13 @@ func (cs *controller) validateVolumeCreateReq(req *csi.CreateVolumeRequest) erro
 		)
 	}
 
+	if err := validateVolumeParameters(req.GetParameters(), req.GetVolumeCapabilities()); err != nil {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"failed to handle create volume request: %v", err,
+		)
+	}
+
 	volCapabilities := req.GetVolumeCapabilities()
 	if volCapabilities == nil {
 		return status.Error(
@@ -164,11 +186,11 @@ func (cs *controller) validateVolumeCreateReq(req *csi.CreateVolumeRequest) erro
 		}
 		if mode := volcap.GetAccessMode(); mode != nil {
 			modeName := csi.VolumeCapability_AccessMode_Mode_name[int32(mode.GetMode())]
-			// we only support SINGLE_NODE_WRITER
-			if mode.GetMode() != csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
+			// the volume can either be written to from a single node or
+			// read from several nodes
+			if !IsSupportedVolumeCapabilityAccessMode(mode.GetMode()) {
 				return status.Errorf(codes.InvalidArgument,
-					"only SINGLE_NODE_WRITER supported, unsupported access mode requested: %s",
-					modeName,
+					"unsupported access mode requested: %s", modeName,
 				)
 			}
 		}
@@ -176,6 +198,62 @@ func (cs *controller) validateVolumeCreateReq(req *csi.CreateVolumeRequest) erro
 	return nil
 }
 
+// validateVolumeParameters validates the parameters of a volume which are
+// applied by the node, they come from the storage class of a persistent
+// volume or from the attributes of an ephemeral one. The mkfs options are
+// validated against the filesystem of every capability of the volume.
+func validateVolumeParameters(params map[string]string, volCaps []*csi.VolumeCapability) error {
+	if _, err := getISCSISessionParams(params); err != nil {
+		return err
+	}
+
+	if hostIface := params[iscsiHostInterfaceKey]; strings.Contains(hostIface, "/") {
+		if _, _, err := net.ParseCIDR(hostIface); err != nil {
+			return fmt.Errorf("invalid storage class parameter %s: %v", iscsiHostInterfaceKey, err)
+		}
+	}
+
+	for _, key := range []string{encryptedKey, adoptExistingFilesystemKey} {
+		if value, ok := params[key]; ok {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("invalid storage class parameter %s: %v", key, err)
+			}
+		}
+	}
+
+	if mkfsOptions := params[mkfsOptionsKey]; mkfsOptions != "" {
+		for _, volCap := range volCaps {
+			if volCap.GetMount() == nil {
+				continue
+			}
+			fsType := volCap.GetMount().GetFsType()
+			if fsType == "" {
+				fsType = defaultFsType
+			}
+			if err := iscsiutils.ValidateMkfsOptions(fsType, strings.Fields(mkfsOptions)); err != nil {
+				return fmt.Errorf("invalid storage class parameter %s: %v", mkfsOptionsKey, err)
+			}
+		}
+	}
+
+	if policy, ok := params[fsckPolicyKey]; ok && !iscsiutils.ValidFsckPolicy(iscsiutils.FsckPolicy(policy)) {
+		return fmt.Errorf(
+			"invalid storage class parameter %s: %q, must be one of never, check, repair-safe or repair-force",
+			fsckPolicyKey, policy,
+		)
+	}
+
+	if schedule, ok := params[trimScheduleKey]; ok {
+		if interval, err := time.ParseDuration(schedule); err != nil || interval < trimSchedulerInterval {
+			return fmt.Errorf(
+				"invalid storage class parameter %s: %q, must be a duration of at least %v",
+				trimScheduleKey, schedule, trimSchedulerInterval,
+			)
+		}
+	}
+	return nil
+}
+
 func (cs *controller) validateDeleteVolumeReq(req *csi.DeleteVolumeRequest) error {
 	volumeID := req.GetVolumeId()
 	if volumeID == "" {
@@ -0,0 +1,85 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestValidateVolumeParameters(t *testing.T) {
+	mountCap := func(fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+			},
+		}
+	}
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+	}
+	tests := map[string]struct {
+		params  map[string]string
+		volCaps []*csi.VolumeCapability
+		wantErr bool
+	}{
+		"no parameters":         {},
+		"host interface name":   {params: map[string]string{iscsiHostInterfaceKey: "eth1"}},
+		"host interface cidr":   {params: map[string]string{iscsiHostInterfaceKey: "10.0.0.0/24"}},
+		"invalid cidr":          {params: map[string]string{iscsiHostInterfaceKey: "10.0.0.0/99"}, wantErr: true},
+		"encrypted":             {params: map[string]string{encryptedKey: "true"}},
+		"invalid encrypted":     {params: map[string]string{encryptedKey: "yes please"}, wantErr: true},
+		"invalid adopt":         {params: map[string]string{adoptExistingFilesystemKey: "maybe"}, wantErr: true},
+		"fsck policy":           {params: map[string]string{fsckPolicyKey: "repair-safe"}},
+		"invalid fsck policy":   {params: map[string]string{fsckPolicyKey: "sometimes"}, wantErr: true},
+		"trim schedule":         {params: map[string]string{trimScheduleKey: "24h"}},
+		"trim schedule minimum": {params: map[string]string{trimScheduleKey: trimSchedulerInterval.String()}},
+		"trim schedule below scheduler interval": {
+			params:  map[string]string{trimScheduleKey: "30s"},
+			wantErr: true,
+		},
+		"invalid trim schedule": {params: map[string]string{trimScheduleKey: "daily"}, wantErr: true},
+		"encrypted with trim schedule": {
+			params: map[string]string{encryptedKey: "true", trimScheduleKey: "12h"},
+		},
+		"mkfs options of default fs": {
+			params:  map[string]string{mkfsOptionsKey: "-i 8192"},
+			volCaps: []*csi.VolumeCapability{mountCap("")},
+		},
+		"mkfs options of other fs": {
+			params:  map[string]string{mkfsOptionsKey: "-T news"},
+			volCaps: []*csi.VolumeCapability{mountCap("ext4"), mountCap("xfs")},
+			wantErr: true,
+		},
+		"mkfs options picking the device": {
+			params:  map[string]string{mkfsOptionsKey: "/dev/sda"},
+			volCaps: []*csi.VolumeCapability{mountCap("ext4")},
+			wantErr: true,
+		},
+		"mkfs options of block volume": {
+			params:  map[string]string{mkfsOptionsKey: "-i 8192"},
+			volCaps: []*csi.VolumeCapability{blockCap},
+		},
+	}
+	for name, test := range tests {
+		err := validateVolumeParameters(test.params, test.volCaps)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: validateVolumeParameters() = %v, want error %t", name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	mountInfoPath = "/proc/self/mountinfo"
+
+	// mountInfoPollTimeout is the time in milliseconds after which poll
+	// returns if the mount table has not changed so that the stop channel
+	// is looked at
+	mountInfoPollTimeout = 1000
+)
+
+// watchMountInfo returns a channel which receives a notification whenever
+// the mount table of the node changes. The kernel flags /proc/self/mountinfo
+// with POLLPRI and POLLERR on every mount or unmount in the mount namespace.
+// Notifications are coalesced, a single pending notification stands for all
+// the changes which happened since the receiver last read the mount table.
+func watchMountInfo(stopCh <-chan struct{}) (<-chan struct{}, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer f.Close()
+		fds := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLPRI | unix.POLLERR}}
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			n, err := unix.Poll(fds, mountInfoPollTimeout)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				logrus.Errorf("failed to poll %s: %v", mountInfoPath, err)
+				return
+			}
+			if n == 0 || fds[0].Revents&(unix.POLLPRI|unix.POLLERR) == 0 {
+				continue
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, nil
+}
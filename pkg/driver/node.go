@@ -17,6 +17,7 @@ limitations under the License.
 package driver
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -25,6 +26,7 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
 	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
+	"github.com/openebs/cstor-csi/pkg/journal"
 	k8snode "github.com/openebs/cstor-csi/pkg/kubernetes/node"
 	utils "github.com/openebs/cstor-csi/pkg/utils"
 	"github.com/sirupsen/logrus"
@@ -42,22 +44,41 @@ type node struct {
 	driver       *CSIDriver
 	capabilities []*csi.NodeServiceCapability
 	mounter      *utils.NodeMounter
+	// ops tracks the operations in progress on the volumes
+	ops *operationManager
+	// journal records the attach state of the staged volumes on the node
+	journal *journal.Journal
 }
 
 // VolumeStatistics represents statistics information of a volume
 type VolumeStatistics struct {
 	availableBytes, totalBytes, usedBytes    int64
 	availableInodes, totalInodes, usedInodes int64
+	// dynamicInodes is set if the filesystem has no inode limit
+	dynamicInodes bool
 }
 
 // NewNode returns a new instance
 // of CSI NodeServer
 func NewNode(d *CSIDriver) csi.NodeServer {
-	return &node{
+	return newNode(d)
+}
+
+func newNode(d *CSIDriver) *node {
+	ns := &node{
 		driver:       d,
 		capabilities: newNodeCapabilities(),
 		mounter:      utils.NewNodeMounter(),
+		ops:          newOperationManager(),
+	}
+	if d.config.StateDir != "" {
+		j, err := journal.New(d.config.StateDir)
+		if err != nil {
+			logrus.Errorf("Node journal is disabled: %v", err)
+		}
+		ns.journal = j
 	}
+	return ns
 }
 
 // NodeGetInfo returns node details
@@ -142,19 +163,22 @@ func (ns *node) NodeStageVolume(
 	volumeID := req.GetVolumeId()
 	stagingTargetPath := req.GetStagingTargetPath()
 
-	err = addVolumeToTransitionList(volumeID, apis.CStorVolumeAttachmentStatusUninitialized)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if err = ns.ops.start(volumeID, apis.CStorVolumeAttachmentStatusUninitialized); err != nil {
+		return nil, err
 	}
-	defer removeVolumeFromTransitionList(volumeID)
+	defer ns.ops.done(volumeID)
 
 	if err := ns.prepareVolumeForNode(req); err != nil {
+		if status.Code(err) == codes.FailedPrecondition {
+			return nil, err
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	if vol, err = utils.GetCStorVolumeAttachment(volumeID + "-" + utils.NodeIDENV); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	ns.ops.update(volumeID, apis.CStorVolumeAttachmentStatusWaitingForVolumeToBeReady)
 	if err = utils.WaitForVolumeReadyAndReachable(vol); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -165,16 +189,47 @@ func (ns *node) NodeStageVolume(
 	}
 	if isMountRequired {
 		vol.Spec.Volume.StagingTargetPath = stagingTargetPath
+		// The staging mount is restored with the same filesystem type and
+		// options if it is lost later on
+		if mnt := req.GetVolumeCapability().GetMount(); mnt != nil {
+			vol.Spec.Volume.FSType = mnt.GetFsType()
+			vol.Spec.Volume.MountOptions = stagingMountFlags(req.GetVolumeCapability(), mnt.GetFsType())
+			if vol.Annotations == nil {
+				vol.Annotations = map[string]string{}
+			}
+			// The mkfs options are recorded so that the format of a stage
+			// interrupted by a restart is completed with them
+			if mkfsOptions := req.GetVolumeContext()[mkfsOptionsKey]; mkfsOptions != "" {
+				vol.Annotations[utils.MkfsOptionsAnnotation] = mkfsOptions
+			}
+			// The filesystem is checked the same way when the staging
+			// mount is restored
+			if policy := req.GetVolumeContext()[fsckPolicyKey]; policy != "" {
+				vol.Annotations[utils.FsckPolicyAnnotation] = policy
+			}
+			// The trim scheduler picks the volume up from its CVA
+			if schedule := req.GetVolumeContext()[trimScheduleKey]; schedule != "" {
+				vol.Annotations[utils.TrimScheduleAnnotation] = schedule
+			}
+		}
+		// The LUKS device is recorded before it is opened so that it is
+		// closed on unstage even if the stage fails midway
+		if isEncrypted(req.GetVolumeContext()) {
+			if vol.Annotations == nil {
+				vol.Annotations = map[string]string{}
+			}
+			vol.Annotations[utils.LUKSDeviceAnnotation] = volumeID
+		}
 		// This is placed to clean up stale iSCSI Sessions
 		vol.Finalizers = []string{utils.NodeIDENV}
-		vol.Spec.Volume.DevicePath = strings.Join([]string{
-			"/dev/disk/by-path/ip", vol.Spec.ISCSI.TargetPortal,
-			"iscsi", vol.Spec.ISCSI.Iqn, "lun", fmt.Sprint(0)}, "-",
-		)
+		vol.Spec.Volume.DevicePath = getISCSIByPath(vol.Spec.ISCSI.TargetPortal, vol.Spec.ISCSI.Iqn)
 		vol, err = utils.UpdateCStorVolumeAttachmentCR(vol)
 		if err != nil {
 			return nil, status.Error(codes.Internal, err.Error())
 		}
+		// The volume is journaled before login so that it can be
+		// logged out even if the API server is down later on
+		ns.journalVolume(vol)
 		// Permission is changed for the local directory before the volume is
 		// mounted on the node. This helps to resolve cases when the CSI driver
 		// Unmounts the volume to remount again in required mount mode(ro/rw),
@@ -183,9 +238,7 @@ func (ns *node) NodeStageVolume(
 		// automatically changed to allow Reads and writes.
 		// And as soon as it is unmounted permissions change
 		// back to what we are setting over here.
-		utils.TransitionVolListLock.Lock()
-		utils.TransitionVolList[volumeID] = apis.CStorVolumeAttachmentStatusMountUnderProgress
-		utils.TransitionVolListLock.Unlock()
+		ns.ops.update(volumeID, apis.CStorVolumeAttachmentStatusMountUnderProgress)
 		// Login to the volume and attempt mount operation on the requested path
 		devicePath, err := ns.attachDisk(vol)
 		if err != nil {
@@ -196,8 +249,26 @@ func (ns *node) NodeStageVolume(
 				return nil, status.Error(codes.Internal, err1.Error())
 			}
 			logrus.Errorf("NodeStageVolume: failed to attachDisk for volume %v, err: %v", volumeID, err)
+			if status.Code(err) == codes.FailedPrecondition {
+				return nil, err
+			}
 			return nil, status.Error(codes.Internal, err.Error())
 		}
+		if vol.Annotations[utils.LUKSDeviceAnnotation] != "" {
+			if devicePath, err = openEncryptedDevice(vol, devicePath, req.GetSecrets()); err != nil {
+				vol.Finalizers = nil
+				// The session is left logged in for the retries of the
+				// stage, as it is when the attach fails
+				if _, uerr := utils.UpdateCStorVolumeAttachmentCR(vol); uerr != nil {
+					return nil, status.Error(codes.Internal, uerr.Error())
+				}
+				logrus.Errorf("NodeStageVolume: failed to open LUKS device of volume %v, err: %v", volumeID, err)
+				if _, ok := status.FromError(err); ok {
+					return nil, err
+				}
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
 		// If the access type is block, do nothing for stage
 		switch req.GetVolumeCapability().GetAccessType().(type) {
 		case *csi.VolumeCapability_Block:
@@ -210,19 +281,52 @@ func (ns *node) NodeStageVolume(
 		}
 
 		logrus.Infof("NodeStageVolume %v: start format and mount operation", volumeID)
-		if err := ns.formatAndMount(req, devicePath); err != nil {
+		fsckResult := vol.Annotations[utils.FsckResultAnnotation]
+		fsType, err := ns.formatAndMount(req, vol, devicePath)
+		if err != nil {
 			vol.Finalizers = nil
 			// There might still be a case that the attach was successful,
 			// therefore not cleaning up the staging path from CR
 			if _, uerr := utils.UpdateCStorVolumeAttachmentCR(vol); uerr != nil {
 				logrus.Errorf("Failed to update cva for %s: %v", volumeID, uerr.Error())
 			}
+			if status.Code(err) == codes.FailedPrecondition {
+				return nil, err
+			}
 			return nil, status.Error(codes.Internal, err.Error())
 		}
+		// The filesystem differs from the requested one if it was adopted
+		// or defaulted, it is recorded so that the staging mount is
+		// restored with it, along with the outcome of its check
+		if (fsType != "" && fsType != vol.Spec.Volume.FSType) ||
+			vol.Annotations[utils.FsckResultAnnotation] != fsckResult {
+			vol.Spec.Volume.FSType = fsType
+			vol.Spec.Volume.MountOptions = stagingMountFlags(req.GetVolumeCapability(), fsType)
+			if _, err := utils.UpdateCStorVolumeAttachmentCR(vol); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			ns.journalVolume(vol)
+		}
+
+		ns.ops.update(volumeID, apis.CStorVolumeAttachmentStatusMounted)
+	}
 
-		utils.TransitionVolListLock.Lock()
-		utils.TransitionVolList[volumeID] = apis.CStorVolumeAttachmentStatusMounted
-		utils.TransitionVolListLock.Unlock()
+	// The group of the pod is applied to the root of the filesystem once
+	// it is staged instead of kubelet changing the group of every file on
+	// each mount
+	if group := req.GetVolumeCapability().GetMount().GetVolumeMountGroup(); group != "" &&
+		!isMultiNodeReadOnly(req.GetVolumeCapability()) {
+		changed, err := ensureVolumeMountGroup(vol, stagingTargetPath, group)
+		if err != nil {
+			logrus.Errorf("NodeStageVolume: failed to apply group %s to volume %v, err: %v", group, volumeID, err)
+			return nil, err
+		}
+		if changed {
+			if err = utils.UpdateCStorVolumeAttachmentAnnotations(vol.Name,
+				map[string]string{utils.VolumeMountGroupAnnotation: group}); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
 	}
 
 	return &csi.NodeStageVolumeResponse{}, nil
@@ -248,20 +352,16 @@ func (ns *node) NodeUnstageVolume(
 	stagingTargetPath := req.GetStagingTargetPath()
 	volumeID := req.GetVolumeId()
 
-	err = addVolumeToTransitionList(volumeID, apis.CStorVolumeAttachmentStatusUninitialized)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if err = ns.ops.start(volumeID, apis.CStorVolumeAttachmentStatusUninitialized); err != nil {
+		return nil, err
 	}
-	defer removeVolumeFromTransitionList(volumeID)
-
-	logrus.Infof("Volume with ID: %v after uninitialising is in '%v' state", volumeID, utils.TransitionVolList[volumeID])
+	defer ns.ops.done(volumeID)
 
 	if vol, err = utils.GetCStorVolumeAttachment(volumeID + "-" + utils.NodeIDENV); err != nil {
-		if k8serror.IsNotFound(err) {
-			logrus.Infof("cva for %s has already been deleted", volumeID)
-			return &csi.NodeUnstageVolumeResponse{}, nil
+		if err = ns.unstageFromJournal(volumeID, stagingTargetPath, err); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
 		}
-		return nil, status.Error(codes.Internal, err.Error())
+		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
 
 	if vol.Spec.Volume.StagingTargetPath == "" {
@@ -281,19 +381,14 @@ func (ns *node) NodeUnstageVolume(
 	// immediately other node deleted this node's CR, in that case iSCSI
 	// target(istgt) will pick up the new one and allow only that node to login,
 	// so all the cases are handled
-	utils.TransitionVolListLock.Lock()
-	utils.TransitionVolList[volumeID] = apis.CStorVolumeAttachmentStatusUnmountUnderProgress
-	logrus.Infof("Volume with ID: %v after starting unmount is in '%v' state", volumeID, utils.TransitionVolList[volumeID])
-	utils.TransitionVolListLock.Unlock()
+	ns.ops.update(volumeID, apis.CStorVolumeAttachmentStatusUnmountUnderProgress)
 
-	if err = iscsiutils.UnmountAndDetachDisk(vol, stagingTargetPath); err != nil {
+	if err = unmountAndDetachDisk(vol, stagingTargetPath); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	utils.TransitionVolListLock.Lock()
-	utils.TransitionVolList[volumeID] = apis.CStorVolumeAttachmentStatusUnmounted
-	logrus.Infof("Volume with ID: %v after successful unmount is in '%v' state", volumeID, utils.TransitionVolList[volumeID])
-	utils.TransitionVolListLock.Unlock()
+	ns.ops.update(volumeID, apis.CStorVolumeAttachmentStatusUnmounted)
+	ns.forgetVolume(volumeID)
 
 	vol.Finalizers = nil
 	vol.Spec.Volume.StagingTargetPath = ""
@@ -320,22 +415,55 @@ func (ns *node) NodePublishVolume(
 	req *csi.NodePublishVolumeRequest,
 ) (*csi.NodePublishVolumeResponse, error) {
 
+	// Inline volumes of the pod are created and staged along with it
+	if isEphemeral(req) {
+		var err error
+		if req, err = ns.stageEphemeralVolume(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
 	volumeID := req.GetVolumeId()
-	err := addVolumeToTransitionList(volumeID, apis.CStorVolumeAttachmentStatusUninitialized)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if err := ns.ops.start(volumeID, apis.CStorVolumeAttachmentStatusUninitialized); err != nil {
+		return nil, err
 	}
-	defer removeVolumeFromTransitionList(volumeID)
+	defer ns.ops.done(volumeID)
 
+	// A volume shared with other nodes is always published read-only
+	readOnly := req.GetReadonly() || isMultiNodeReadOnly(req.GetVolumeCapability())
 	mountOptions := []string{"bind"}
-	if req.GetReadonly() {
+	if readOnly {
 		mountOptions = append(mountOptions, "ro")
 	}
 	vol, err := utils.GetCStorVolumeAttachment(volumeID + "-" + utils.NodeIDENV)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	vol.Spec.Volume.TargetPath = req.GetTargetPath()
+	// A SINGLE_NODE_SINGLE_WRITER volume can be used by a single pod
+	if req.GetVolumeCapability().GetAccessMode().GetMode() ==
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER {
+		for _, target := range utils.PublishTargets(vol) {
+			if target.Path != req.GetTargetPath() {
+				return nil, status.Errorf(codes.FailedPrecondition,
+					"volume %s is already published at %s", volumeID, target.Path)
+			}
+		}
+	}
+	// Every pod on the node has to use the volume with the same group, the
+	// one applied when it was staged unless it was staged without one
+	if group := req.GetVolumeCapability().GetMount().GetVolumeMountGroup(); group != "" &&
+		!isMultiNodeReadOnly(req.GetVolumeCapability()) {
+		if _, err := ensureVolumeMountGroup(vol, req.GetStagingTargetPath(), group); err != nil {
+			return nil, err
+		}
+	}
+	// The publish is restored with the same options if it is lost later
+	// on, a read-only publish must never come back as read-write
+	utils.AddPublishTarget(vol, utils.PublishTarget{
+		Path:       req.GetTargetPath(),
+		ReadOnly:   readOnly,
+		MountFlags: req.GetVolumeCapability().GetMount().GetMountFlags(),
+	})
 	if _, err = utils.UpdateCStorVolumeAttachmentCR(vol); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -361,21 +489,31 @@ func (ns *node) NodeUnpublishVolume(
 	volumeID := req.GetVolumeId()
 	target := req.GetTargetPath()
 
-	err := addVolumeToTransitionList(volumeID, apis.CStorVolumeAttachmentStatusUninitialized)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if ephemeralVolumeHandle.MatchString(volumeID) {
+		return ns.unpublishEphemeralVolume(ctx, req)
 	}
-	defer removeVolumeFromTransitionList(volumeID)
 
-	notMnt, err := ns.mounter.IsLikelyNotMountPoint(target)
-	if (err == nil && notMnt) || os.IsNotExist(err) {
-		logrus.Warningf("NodeUnpublishVolume: %s is not mounted, err: %v", target, err)
-		return &csi.NodeUnpublishVolumeResponse{}, nil
+	if err := ns.ops.start(volumeID, apis.CStorVolumeAttachmentStatusUninitialized); err != nil {
+		return nil, err
 	}
+	defer ns.ops.done(volumeID)
 
-	logrus.Infof("NodeUnpublishVolume: unmounting %s", target)
-	if err := ns.mounter.Unmount(target); err != nil {
-		return nil, status.Errorf(codes.Internal, "Could not unmount %q: %v", target, err)
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(target)
+	switch {
+	case (err == nil && notMnt) || os.IsNotExist(err):
+		// The target is still forgotten on the CVA so that it is not
+		// mounted again by the monitor
+		logrus.Warningf("NodeUnpublishVolume: %s is not mounted, err: %v", target, err)
+	case iscsiutils.IsCorruptedMount(err):
+		logrus.Warningf("NodeUnpublishVolume: %s is a corrupted mount point: %v", target, err)
+		if err := iscsiutils.ForceUnmount(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "Could not unmount %q: %v", target, err)
+		}
+	default:
+		logrus.Infof("NodeUnpublishVolume: unmounting %s", target)
+		if err := ns.mounter.Unmount(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "Could not unmount %q: %v", target, err)
+		}
 	}
 	vol, err := utils.GetCStorVolumeAttachment(volumeID + "-" + utils.NodeIDENV)
 	if err != nil {
@@ -385,7 +523,12 @@ func (ns *node) NodeUnpublishVolume(
 		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	vol.Spec.Volume.TargetPath = ""
+	// The volume may still be published at other paths, only this target
+	// is removed from the CVA
+	if !isPublishedAt(vol, target) {
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+	utils.RemovePublishTarget(vol, target)
 	if _, err = utils.UpdateCStorVolumeAttachmentCR(vol); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -396,7 +539,7 @@ func (ns *node) NodeUnpublishVolume(
 // TODO
 // Verify if this needs to be implemented
 //
-// # NodeExpandVolume resizes the filesystem if required
+// # NodeExpandVolume grows the device of the volume and its filesystem if any
 //
 // If ControllerExpandVolumeResponse returns true in
 // node_expansion_required then FileSystemResizePending
@@ -409,23 +552,27 @@ func (ns *node) NodeExpandVolume(
 	req *csi.NodeExpandVolumeRequest,
 ) (*csi.NodeExpandVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
-	err := addVolumeToTransitionList(volumeID, apis.CStorVolumeAttachmentStatusResizeInProgress)
-	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"failed to handle NodeExpandVolumeRequest for %s, {%s}",
-			req.VolumeId,
-			err.Error(),
-		)
+	if err := ns.ops.start(volumeID, apis.CStorVolumeAttachmentStatusResizeInProgress); err != nil {
+		return nil, err
 	}
-	defer removeVolumeFromTransitionList(volumeID)
+	defer ns.ops.done(volumeID)
 
 	vol, err := utils.GetCStorVolumeAttachment(volumeID + "-" + utils.NodeIDENV)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if err = iscsiutils.ResizeVolume(req.GetVolumePath(), vol); err != nil {
+	requiredBytes := req.GetCapacityRange().GetRequiredBytes()
+	capacity, err := iscsiutils.ResizeVolume(req.GetVolumePath(), vol, requiredBytes,
+		vol.Annotations[utils.LUKSDeviceAnnotation], req.GetSecrets()[encryptionPassphraseKey])
+	if errors.Is(err, iscsiutils.ErrDeviceNotGrown) {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"failed to handle NodeExpandVolumeRequest for %s, {%s}",
+			req.VolumeId,
+			err.Error(),
+		)
+	} else if err != nil {
 		return nil, status.Errorf(
 			codes.Internal,
 			"failed to handle NodeExpandVolumeRequest for %s, {%s}",
@@ -435,7 +582,7 @@ func (ns *node) NodeExpandVolume(
 	}
 
 	return &csi.NodeExpandVolumeResponse{
-		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+		CapacityBytes: capacity,
 	}, nil
 }
 
@@ -456,6 +603,9 @@ func (ns *node) NodeGetVolumeStats(
 	}
 
 	mounted, err := ns.mounter.ExistsPath(volumePath)
+	if iscsiutils.IsCorruptedMount(err) {
+		return abnormalVolumeStats(volumePath, err), nil
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to check if volume path %q is mounted: %s", volumePath, err)
 	}
@@ -480,31 +630,53 @@ func (ns *node) NodeGetVolumeStats(
 					Total: bcap,
 				},
 			},
+			VolumeCondition: &csi.VolumeCondition{},
 		}, nil
 	}
 	stats, err := ns.GetStatistics(volumePath)
+	if iscsiutils.IsCorruptedMount(err) {
+		return abnormalVolumeStats(volumePath, err), nil
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to retrieve capacity statistics for volume path %q: %s", volumePath, err)
 	}
 
-	return &csi.NodeGetVolumeStatsResponse{
-		Usage: []*csi.VolumeUsage{
-			&csi.VolumeUsage{
-				Available: stats.availableBytes,
-				Total:     stats.totalBytes,
-				Used:      stats.usedBytes,
-				Unit:      csi.VolumeUsage_BYTES,
-			},
-			&csi.VolumeUsage{
-				Available: stats.availableInodes,
-				Total:     stats.totalInodes,
-				Used:      stats.usedInodes,
-				Unit:      csi.VolumeUsage_INODES,
-			},
+	usage := []*csi.VolumeUsage{
+		&csi.VolumeUsage{
+			Available: stats.availableBytes,
+			Total:     stats.totalBytes,
+			Used:      stats.usedBytes,
+			Unit:      csi.VolumeUsage_BYTES,
 		},
+	}
+	// Filesystems which allocate inodes on demand report no inode limit
+	if !stats.dynamicInodes {
+		usage = append(usage, &csi.VolumeUsage{
+			Available: stats.availableInodes,
+			Total:     stats.totalInodes,
+			Used:      stats.usedInodes,
+			Unit:      csi.VolumeUsage_INODES,
+		})
+	}
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage:           usage,
+		VolumeCondition: &csi.VolumeCondition{},
 	}, nil
 }
 
+// abnormalVolumeStats reports the volume as abnormal when its mount point
+// is corrupted, kubelet surfaces the condition as an event on the pod
+// instead of retrying a failing stats call forever
+func abnormalVolumeStats(volumePath string, err error) *csi.NodeGetVolumeStatsResponse {
+	logrus.Warningf("NodeGetVolumeStats: %s is a corrupted mount point: %v", volumePath, err)
+	return &csi.NodeGetVolumeStatsResponse{
+		VolumeCondition: &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("volume path %s is not accessible: %v", volumePath, err),
+		},
+	}
+}
+
 // GetStatistics get the statistics for a given volume path
 func (ns *node) GetStatistics(volumePath string) (VolumeStatistics, error) {
 	var statfs unix.Statfs_t
@@ -523,6 +695,9 @@ func (ns *node) GetStatistics(volumePath string) (VolumeStatistics, error) {
 		totalInodes:     int64(statfs.Files),
 		usedInodes:      int64(statfs.Files) - int64(statfs.Ffree),
 	}
+	if fs, ok := iscsiutils.LookupFilesystemByMagic(int64(statfs.Type)); ok {
+		volStats.dynamicInodes = fs.DynamicInodes
+	}
 
 	return volStats, nil
 }
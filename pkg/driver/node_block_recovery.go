@@ -0,0 +1,187 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	reasonBlockDeviceStale          = "BlockDeviceStale"
+	reasonBlockDeviceRecovered      = "BlockDeviceRecovered"
+	reasonBlockDeviceRecoveryFailed = "BlockDeviceRecoveryFailed"
+
+	// blockDeviceWaitTimeout is the time given to udev to create the
+	// by-path link of the LUN after the session is rescanned
+	blockDeviceWaitTimeout = 10 * time.Second
+)
+
+// stalePublish is a publish target of a raw block volume which is not bound
+// to the current device of the LUN
+type stalePublish struct {
+	target utils.PublishTarget
+	reason string
+}
+
+func (sp stalePublish) String() string {
+	return sp.target.Path + ": " + sp.reason
+}
+
+// staleBlockPublishes verifies that every publish path of a raw block volume
+// is bound to the device the by-path link of its LUN currently points to.
+// The device node changes whenever the session is re-established, the pods
+// keep the old one which can no longer serve IOs.
+func staleBlockPublishes(vol *apis.CStorVolumeAttachment) []stalePublish {
+	var stale []stalePublish
+	for _, target := range utils.PublishTargets(vol) {
+		if reason := staleBlockPublish(utils.MountDevicePath(vol), target.Path); reason != "" {
+			stale = append(stale, stalePublish{target: target, reason: reason})
+		}
+	}
+	return stale
+}
+
+// staleBlockPublish returns why the given publish path is not bound to the
+// device the by-path link points to or an empty string if it is
+func staleBlockPublish(byPath, targetPath string) string {
+	var target, device unix.Stat_t
+	if err := unix.Stat(targetPath, &target); err != nil {
+		// The publish path has been removed by kubelet
+		return ""
+	}
+	devicePath, err := filepath.EvalSymlinks(byPath)
+	if err != nil {
+		return fmt.Sprintf("device %s is missing", byPath)
+	}
+	if err := unix.Stat(devicePath, &device); err != nil {
+		return fmt.Sprintf("device %s is missing", devicePath)
+	}
+	if target.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return "publish path is not bound to a block device"
+	}
+	if target.Rdev != device.Rdev {
+		return fmt.Sprintf("publish path is bound to a stale device, current device is %s", devicePath)
+	}
+	return ""
+}
+
+// recoverBlockVolume brings back the LUN of the raw block volume if it is
+// missing, binds it again at the stale publish paths and records the
+// outcome on the CVA and as an event
+func (ns *node) recoverBlockVolume(vol *apis.CStorVolumeAttachment, stale []stalePublish) error {
+	volumeID := vol.Spec.Volume.Name
+	reasons := make([]string, 0, len(stale))
+	targets := make([]utils.PublishTarget, 0, len(stale))
+	for _, sp := range stale {
+		reasons = append(reasons, sp.String())
+		targets = append(targets, sp.target)
+	}
+	reason := strings.Join(reasons, "; ")
+	logrus.Warningf("Block volume %s: %s, attempting recovery", volumeID, reason)
+	utils.RecordCVAEvent(vol, corev1.EventTypeWarning, reasonBlockDeviceStale,
+		"block volume %s on node %s: %s", volumeID, utils.NodeIDENV, reason)
+
+	state, result := apis.CStorVolumeAttachmentStatusRaw, "recovered"
+	err := ns.rebindBlockDevice(vol, targets)
+	if err != nil {
+		state, result = apis.CStorVolumeAttachmentStatusMountFailed, fmt.Sprintf("failed: %v", err)
+		logrus.Errorf("Block volume %s recovery failed: %v", volumeID, err)
+		utils.RecordCVAEvent(vol, corev1.EventTypeWarning, reasonBlockDeviceRecoveryFailed,
+			"failed to recover block device: %v", err)
+	} else {
+		logrus.Infof("Block volume %s recovery successful", volumeID)
+		utils.RecordCVAEvent(vol, corev1.EventTypeNormal, reasonBlockDeviceRecovered,
+			"recovered block device at %d publish paths", len(targets))
+	}
+
+	annotations := map[string]string{
+		utils.BlockDeviceRecoveryAnnotation: fmt.Sprintf("%s %s: %s",
+			time.Now().UTC().Format(time.RFC3339), reason, result),
+	}
+	if uerr := utils.UpdateCStorVolumeAttachmentStatus(vol.Name, state, annotations); uerr != nil {
+		logrus.Errorf("failed to record block device recovery on cva %s: %v", vol.Name, uerr)
+	}
+	return err
+}
+
+// rebindBlockDevice repairs the iSCSI session of the volume if its LUN is
+// missing and bind mounts the LUN at the given publish paths again
+func (ns *node) rebindBlockDevice(vol *apis.CStorVolumeAttachment, targets []utils.PublishTarget) error {
+	if _, err := os.Stat(vol.Spec.Volume.DevicePath); err != nil {
+		// The LUKS device is left on top of the disk which is gone
+		if name := vol.Annotations[utils.LUKSDeviceAnnotation]; name != "" {
+			return fmt.Errorf("disk of LUKS device %s is missing, the volume has to be staged again", name)
+		}
+		sessions, err := iscsiutils.ListSessionsForIQN(vol.Spec.ISCSI.Iqn)
+		if err != nil {
+			return err
+		}
+		problem, session := diagnoseISCSISession(vol, sessions)
+		// The session looks fine but the LUN is gone, rescanning the
+		// session brings it back
+		if problem == sessionHealthy {
+			problem = sessionDeviceOffline
+		}
+		if err := ns.repairISCSISession(vol, problem, session); err != nil {
+			return err
+		}
+		if !waitForDevice(vol.Spec.Volume.DevicePath, blockDeviceWaitTimeout) {
+			return fmt.Errorf("device %s did not show up after %s of session",
+				vol.Spec.Volume.DevicePath, problem)
+		}
+	}
+
+	var errs []string
+	for _, target := range targets {
+		if err := ns.mounter.Unmount(target.Path); err != nil {
+			logrus.Warningf("failed to unmount %s: %v", target.Path, err)
+		}
+		if err := ns.mounter.Mount(utils.MountDevicePath(vol), target.Path, "", target.MountOptions()); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to bind device: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// waitForDevice waits till the given device path exists or the timeout
+// expires
+func waitForDevice(devicePath string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := os.Stat(devicePath); err == nil {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Second)
+	}
+}
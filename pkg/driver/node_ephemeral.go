@@ -0,0 +1,306 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// ephemeralKey is set to true in the volume context of the inline
+	// volumes of a pod, which live and die with it
+	ephemeralKey = "csi.storage.k8s.io/ephemeral"
+
+	// ephemeralSizeKey is the volume attribute which holds the size of an
+	// ephemeral volume, e.g. 5Gi
+	ephemeralSizeKey = "size"
+
+	// podNameKey, podNamespaceKey and podUIDKey are set in the volume
+	// context by kubelet to the pod the volume is published for
+	podNameKey      = "csi.storage.k8s.io/pod.name"
+	podNamespaceKey = "csi.storage.k8s.io/pod.namespace"
+	podUIDKey       = "csi.storage.k8s.io/pod.uid"
+)
+
+// ephemeralRejectedKeys are the parameters which can't be set through the
+// attributes of an ephemeral volume. These are written by the pod author
+// rather than the cluster admin, who keeps control over the node's network
+// and over the targets the node logs in to.
+// The pool cluster, replica count and volume policy of the volume are left
+// to the pod author like the storage class of a PVC, the inline volumes of
+// the driver have to be disallowed by admission policy where that is not
+// wanted.
+var ephemeralRejectedKeys = []string{
+	iscsiHostInterfaceKey,
+	iscsiutils.TargetPortalKey,
+	iscsiutils.IqnKey,
+	iscsiutils.LunKey,
+	iscsiutils.ISCSIInterfaceKey,
+}
+
+// ephemeralVolumeHandle matches the handles kubelet gives to ephemeral
+// volumes, csi- followed by a sha256 of the pod UID and the volume name
+var ephemeralVolumeHandle = regexp.MustCompile(`^csi-[0-9a-f]{64}$`)
+
+// ephemeralVolumeName returns the name of the CVC backing the ephemeral
+// volume with the given handle. The handle itself is too long to be used as
+// it ends up in label values, which are limited to 63 characters.
+func ephemeralVolumeName(volumeID string) string {
+	sum := sha256.Sum256([]byte(volumeID))
+	return "csi-" + hex.EncodeToString(sum[:16])
+}
+
+// ephemeralStagingPath returns the path the ephemeral volume published at
+// the given target is staged at, next to the target in the pod's volume dir
+func ephemeralStagingPath(targetPath string) string {
+	return filepath.Join(filepath.Dir(targetPath), "staging")
+}
+
+// isEphemeral returns true if the volume of the publish request is an
+// inline volume of the pod
+func isEphemeral(req *csi.NodePublishVolumeRequest) bool {
+	return req.GetVolumeContext()[ephemeralKey] == "true"
+}
+
+// stageEphemeralVolume provisions the CVC of an ephemeral volume if it does
+// not exist yet, waits for it to be bound and stages it. It returns the
+// publish request of the staged volume. Kubelet retries the publish until
+// the volume gets staged. The attributes of the volume are validated like
+// storage class parameters, except for ephemeralRejectedKeys.
+func (ns *node) stageEphemeralVolume(
+	ctx context.Context,
+	req *csi.NodePublishVolumeRequest,
+) (*csi.NodePublishVolumeRequest, error) {
+	volCtx := req.GetVolumeContext()
+	if req.GetVolumeCapability().GetMount() == nil {
+		return nil, status.Error(codes.InvalidArgument,
+			"ephemeral volumes can only be used as a filesystem")
+	}
+	if req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
+	}
+	for _, key := range []string{"cstorPoolCluster", "replicaCount", ephemeralSizeKey} {
+		if volCtx[key] == "" {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"missing volume attribute %s of ephemeral volume", key)
+		}
+	}
+	for _, key := range ephemeralRejectedKeys {
+		if _, ok := volCtx[key]; ok {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"volume attribute %s can't be set on ephemeral volumes", key)
+		}
+	}
+	size, err := resource.ParseQuantity(volCtx[ephemeralSizeKey])
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"invalid volume attribute %s of ephemeral volume: %v", ephemeralSizeKey, err)
+	}
+	// The volume is formatted with the default filesystem if it has none
+	fsType := req.GetVolumeCapability().GetMount().GetFsType()
+	if fsType != "" && !isValidFStype(fsType) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"invalid fsType of ephemeral volume: %s", fsType)
+	}
+	if err = validateVolumeParameters(volCtx, []*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"invalid volume attributes of ephemeral volume: %v", err)
+	}
+
+	volName := ephemeralVolumeName(req.GetVolumeId())
+	cvc, err := utils.GetVolume(volName)
+	switch {
+	case k8serror.IsNotFound(err):
+		logrus.Infof("Provisioning ephemeral volume %s for %s", volName, req.GetTargetPath())
+		// The pod is recorded so that the CVC is deleted by the node
+		// if the pod goes away without the volume being unpublished
+		var podAnnotations map[string]string
+		if uid := volCtx[podUIDKey]; uid != "" {
+			podAnnotations = map[string]string{
+				utils.EphemeralPodAnnotation:    volCtx[podNamespaceKey] + "/" + volCtx[podNameKey],
+				utils.EphemeralPodUIDAnnotation: uid,
+			}
+		}
+		err = utils.ProvisionVolume(size.Value(), volName, volCtx["replicaCount"],
+			volCtx["cstorPoolCluster"], "", ns.driver.config.NodeID,
+			volCtx["cstorVolumePolicy"], "", "", podAnnotations)
+		if err != nil && !k8serror.IsAlreadyExists(err) {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	case cvc.DeletionTimestamp != nil:
+		return nil, status.Errorf(codes.Unavailable,
+			"ephemeral volume %s is still being deleted", volName)
+	}
+	if err = waitForCVCBound(volName); err != nil {
+		return nil, err
+	}
+
+	stagingTargetPath := ephemeralStagingPath(req.GetTargetPath())
+	_, err = ns.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volName,
+		StagingTargetPath: stagingTargetPath,
+		VolumeCapability:  req.GetVolumeCapability(),
+		Secrets:           req.GetSecrets(),
+		VolumeContext:     volCtx,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:          volName,
+		StagingTargetPath: stagingTargetPath,
+		TargetPath:        req.GetTargetPath(),
+		VolumeCapability:  req.GetVolumeCapability(),
+		Readonly:          req.GetReadonly(),
+		Secrets:           req.GetSecrets(),
+		VolumeContext:     volCtx,
+	}, nil
+}
+
+// waitForCVCBound waits for the CVC of the volume to be bound for as long
+// as the volume is waited for to be ready
+func waitForCVCBound(volName string) error {
+	for retries := 0; ; retries++ {
+		bound, err := utils.IsCVCBound(volName)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if bound {
+			return nil
+		}
+		if retries >= utils.VolumeWaitRetryCount {
+			return status.Errorf(codes.Unavailable,
+				"waiting for CVC of ephemeral volume %s to be bound", volName)
+		}
+		time.Sleep(utils.VolumeWaitTimeout * time.Second)
+	}
+}
+
+// unpublishEphemeralVolume unpublishes the ephemeral volume published at the
+// target of the request, unstages it and deletes its CVC
+func (ns *node) unpublishEphemeralVolume(
+	ctx context.Context,
+	req *csi.NodeUnpublishVolumeRequest,
+) (*csi.NodeUnpublishVolumeResponse, error) {
+	volName := ephemeralVolumeName(req.GetVolumeId())
+	_, err := ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   volName,
+		TargetPath: req.GetTargetPath(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The staging path is left behind if the stage did not get through
+	if err = os.Remove(ephemeralStagingPath(req.GetTargetPath())); err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err = ns.deleteEphemeralVolume(ctx, volName); err != nil {
+		return nil, err
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// deleteEphemeralVolume unpublishes and unstages the ephemeral volume as
+// recorded on its CVA, if any, and deletes its CVC
+func (ns *node) deleteEphemeralVolume(ctx context.Context, volName string) error {
+	vol, err := utils.GetCStorVolumeAttachment(volName + "-" + utils.NodeIDENV)
+	switch {
+	case err == nil && vol.Spec.Volume.StagingTargetPath != "":
+		for _, target := range utils.PublishTargets(vol) {
+			_, err = ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   volName,
+				TargetPath: target.Path,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		_, err = ns.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+			VolumeId:          volName,
+			StagingTargetPath: vol.Spec.Volume.StagingTargetPath,
+		})
+		if err != nil {
+			return err
+		}
+		if err = os.Remove(vol.Spec.Volume.StagingTargetPath); err != nil && !os.IsNotExist(err) {
+			return status.Error(codes.Internal, err.Error())
+		}
+	case err == nil:
+		// The stage did not get through, there is nothing mounted
+		if err = utils.DeleteCStorVolumeAttachmentCR(vol.Name); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	case !k8serror.IsNotFound(err):
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	logrus.Infof("Deleting ephemeral volume %s", volName)
+	if err = utils.DeleteVolume(volName); err != nil && !k8serror.IsNotFound(err) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// cleanupEphemeralVolumes deletes the ephemeral volumes provisioned by this
+// node whose pod no longer exists. These are left behind when the pod is
+// deleted while the node is down or when kubelet gives up on a failed
+// unpublish of the volume.
+func (ns *node) cleanupEphemeralVolumes() {
+	cvcList, err := utils.ListVolumes()
+	if err != nil {
+		logrus.Errorf("failed to list cvc for ephemeral volume cleanup: %v", err)
+		return
+	}
+	for _, cvc := range cvcList.Items {
+		uid := cvc.Annotations[utils.EphemeralPodUIDAnnotation]
+		if uid == "" || cvc.Publish.NodeID != ns.driver.config.NodeID || cvc.DeletionTimestamp != nil {
+			continue
+		}
+		pod := cvc.Annotations[utils.EphemeralPodAnnotation]
+		namespace, name, _ := strings.Cut(pod, "/")
+		exists, err := utils.PodExists(namespace, name, uid)
+		if err != nil {
+			logrus.Errorf("failed to get pod %s of ephemeral volume %s: %v", pod, cvc.Name, err)
+			continue
+		}
+		if exists {
+			continue
+		}
+		logrus.Infof("Pod %s of ephemeral volume %s is gone, deleting the volume", pod, cvc.Name)
+		if err := ns.deleteEphemeralVolume(context.Background(), cvc.Name); err != nil {
+			logrus.Errorf("failed to delete ephemeral volume %s: %v", cvc.Name, err)
+		}
+	}
+}
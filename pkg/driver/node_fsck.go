@@ -0,0 +1,90 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	reasonFilesystemChecked     = "FilesystemChecked"
+	reasonFilesystemRepaired    = "FilesystemRepaired"
+	reasonFilesystemCheckFailed = "FilesystemCheckFailed"
+
+	// fsckOutputLimit is the number of trailing bytes of the fsck output
+	// kept on the CVA and in events
+	fsckOutputLimit = 1024
+)
+
+// checkFilesystem applies the fsck policy to the filesystem on the device
+// before it is mounted. The result is recorded on the given CVA, which the
+// caller updates, and as an event. The mount is refused with
+// FailedPrecondition if errors are left on the filesystem.
+func checkFilesystem(vol *apis.CStorVolumeAttachment, devicePath, fsType string, policy iscsiutils.FsckPolicy) error {
+	if policy == iscsiutils.FsckNever {
+		return nil
+	}
+	result, err := iscsiutils.CheckFilesystem(devicePath, fsType, policy)
+	if err != nil {
+		return err
+	}
+	output := result.Output
+	if len(output) > fsckOutputLimit {
+		output = "..." + output[len(output)-fsckOutputLimit:]
+	}
+	if vol.Annotations == nil {
+		vol.Annotations = map[string]string{}
+	}
+	vol.Annotations[utils.FsckResultAnnotation] = fmt.Sprintf("%s %s\n%s",
+		time.Now().UTC().Format(time.RFC3339), result, output)
+
+	switch {
+	case result.Unrecoverable:
+		logrus.Errorf("Volume %s: filesystem check found errors on %s: %s",
+			vol.Spec.Volume.Name, devicePath, result.Output)
+		vol.Status = apis.CStorVolumeAttachmentStatusMountFailed
+		utils.RecordCVAEvent(vol, corev1.EventTypeWarning, reasonFilesystemCheckFailed,
+			"%s filesystem check on node %s, refusing to mount: %s", result, utils.NodeIDENV, output)
+		return status.Errorf(codes.FailedPrecondition,
+			"%s filesystem on %s has errors (fsck policy %s), see the %s annotation of cva %s",
+			fsType, devicePath, policy, utils.FsckResultAnnotation, vol.Name)
+	case result.Repaired:
+		logrus.Warningf("Volume %s: filesystem check repaired %s: %s",
+			vol.Spec.Volume.Name, devicePath, result.Output)
+		utils.RecordCVAEvent(vol, corev1.EventTypeWarning, reasonFilesystemRepaired,
+			"%s filesystem check on node %s: %s", result, utils.NodeIDENV, output)
+	case result.LogReplayPending:
+		// The mount replays the log, the filesystem is left unchecked
+		logrus.Infof("Volume %s: filesystem check of %s deferred to the log replay of the mount",
+			vol.Spec.Volume.Name, devicePath)
+		utils.RecordCVAEvent(vol, corev1.EventTypeNormal, reasonFilesystemChecked,
+			"%s filesystem check on node %s, the filesystem was not checked", result, utils.NodeIDENV)
+	default:
+		utils.RecordCVAEvent(vol, corev1.EventTypeNormal, reasonFilesystemChecked,
+			"%s filesystem check on node %s", result, utils.NodeIDENV)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/cstor-csi/pkg/journal"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// journalRecord returns the attach state of the volume to be journaled
+func journalRecord(vol *apis.CStorVolumeAttachment) *journal.Record {
+	return &journal.Record{
+		VolumeID:       vol.Spec.Volume.Name,
+		AttachmentName: vol.Name,
+		IQN:            vol.Spec.ISCSI.Iqn,
+		Portal:         vol.Spec.ISCSI.TargetPortal,
+		Lun:            vol.Spec.ISCSI.Lun,
+		Iface:          vol.Spec.ISCSI.IscsiInterface,
+		DevicePath:     vol.Spec.Volume.DevicePath,
+		StagingPath:    vol.Spec.Volume.StagingTargetPath,
+		FSType:         vol.Spec.Volume.FSType,
+		LUKSDevice:     vol.Annotations[utils.LUKSDeviceAnnotation],
+	}
+}
+
+// journaledAttachment builds the CVA of a journaled volume with the fields
+// required to unmount and logout of it
+func journaledAttachment(rec *journal.Record) *apis.CStorVolumeAttachment {
+	vol := &apis.CStorVolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: rec.AttachmentName},
+	}
+	vol.Spec.Volume.Name = rec.VolumeID
+	vol.Spec.Volume.DevicePath = rec.DevicePath
+	vol.Spec.Volume.StagingTargetPath = rec.StagingPath
+	vol.Spec.Volume.FSType = rec.FSType
+	if rec.LUKSDevice != "" {
+		vol.Annotations = map[string]string{utils.LUKSDeviceAnnotation: rec.LUKSDevice}
+	}
+	vol.Spec.ISCSI.Iqn = rec.IQN
+	vol.Spec.ISCSI.TargetPortal = rec.Portal
+	vol.Spec.ISCSI.Lun = rec.Lun
+	vol.Spec.ISCSI.IscsiInterface = rec.Iface
+	return vol
+}
+
+// journalVolume records the attach state of the staged volume on the node
+func (ns *node) journalVolume(vol *apis.CStorVolumeAttachment) {
+	if err := ns.journal.Put(journalRecord(vol)); err != nil {
+		logrus.Errorf("Volume %s: %v", vol.Spec.Volume.Name, err)
+	}
+}
+
+// forgetVolume removes the journaled attach state of the volume once it
+// has been logged out
+func (ns *node) forgetVolume(volumeID string) {
+	if err := ns.journal.Delete(volumeID); err != nil {
+		logrus.Errorf("Volume %s: %v", volumeID, err)
+	}
+}
+
+// unstageFromJournal unmounts and logs out of the volume using its journaled
+// attach state when its CVA can't be fetched. If the CVA has been deleted
+// the volume no longer needs to be tracked, otherwise the API server is not
+// reachable and the record is kept till the CVA is cleaned up on the next
+// reconcile of the journal.
+func (ns *node) unstageFromJournal(volumeID, stagingTargetPath string, cvaErr error) error {
+	rec, err := ns.journal.Get(volumeID)
+	if err != nil {
+		logrus.Errorf("Volume %s: %v", volumeID, err)
+	}
+	if rec == nil {
+		if k8serror.IsNotFound(cvaErr) {
+			logrus.Infof("cva for %s has already been deleted", volumeID)
+			return nil
+		}
+		return cvaErr
+	}
+	if rec.Unstaged {
+		return nil
+	}
+
+	logrus.Warningf("Volume %s: unstaging using the journaled state, cva not available: %v",
+		volumeID, cvaErr)
+	if err := unmountAndDetachDisk(journaledAttachment(rec), stagingTargetPath); err != nil {
+		return err
+	}
+	if k8serror.IsNotFound(cvaErr) {
+		ns.forgetVolume(volumeID)
+		return nil
+	}
+	rec.Unstaged = true
+	return ns.journal.Put(rec)
+}
+
+// reconcileJournal reconciles the volumes journaled on the node against
+// their CVAs:
+//   - volumes whose CVA is gone are logged out and forgotten
+//   - the CVAs of volumes unstaged while the API server was unreachable
+//     are cleaned up
+//   - staged volumes missing in the journal are journaled
+//
+// Records are kept as they are if the API server is still unreachable.
+func (ns *node) reconcileJournal() {
+	if ns.journal == nil {
+		return
+	}
+	records, err := ns.journal.List()
+	if err != nil {
+		logrus.Errorf("Failed to reconcile node journal: %v", err)
+		return
+	}
+	journaled := map[string]bool{}
+	for _, rec := range records {
+		journaled[rec.VolumeID] = true
+		if err := ns.reconcileRecord(rec); err != nil {
+			logrus.Errorf("Volume %s: failed to reconcile journal record: %v", rec.VolumeID, err)
+		}
+	}
+
+	csivolList, err := utils.GetVolListForNode()
+	if err != nil {
+		logrus.Errorf("Failed to get cva list, err: %v", err)
+		return
+	}
+	for i := range csivolList.Items {
+		vol := &csivolList.Items[i]
+		if journaled[vol.Spec.Volume.Name] || vol.DeletionTimestamp != nil ||
+			len(vol.Finalizers) == 0 || vol.Spec.Volume.StagingTargetPath == "" {
+			continue
+		}
+		logrus.Infof("Volume %s: journaling staged volume", vol.Spec.Volume.Name)
+		ns.journalVolume(vol)
+	}
+}
+
+func (ns *node) reconcileRecord(rec *journal.Record) error {
+	if err := ns.ops.start(rec.VolumeID, apis.CStorVolumeAttachmentStatusUninitialized); err != nil {
+		// The volume is being cleaned up, which forgets it once done
+		logrus.Infof("Skipping journal reconcile: %v", err)
+		return nil
+	}
+	defer ns.ops.done(rec.VolumeID)
+
+	vol, err := utils.GetCStorVolumeAttachment(rec.AttachmentName)
+	switch {
+	case k8serror.IsNotFound(err):
+		if !rec.Unstaged {
+			logrus.Infof("Volume %s: cva is gone, logging out of the journaled volume", rec.VolumeID)
+			if err := unmountAndDetachDisk(journaledAttachment(rec), rec.StagingPath); err != nil {
+				return err
+			}
+		}
+		ns.forgetVolume(rec.VolumeID)
+	case err != nil:
+		return err
+	case rec.Unstaged:
+		logrus.Infof("Volume %s: cleaning up cva %s of the volume unstaged earlier", rec.VolumeID, vol.Name)
+		vol.Finalizers = nil
+		vol.Spec.Volume.StagingTargetPath = ""
+		if _, err := utils.UpdateCStorVolumeAttachmentCR(vol); err != nil {
+			return err
+		}
+		if err := utils.DeleteCStorVolumeAttachmentCR(vol.Name); err != nil && !k8serror.IsNotFound(err) {
+			return fmt.Errorf("failed to delete cva %s: %v", vol.Name, err)
+		}
+		ns.forgetVolume(rec.VolumeID)
+	case vol.DeletionTimestamp != nil:
+		// cleanup forgets the volume once it is logged out
+	case len(vol.Finalizers) == 0:
+		// The volume was never logged in or its stage failed
+		ns.forgetVolume(rec.VolumeID)
+	default:
+		ns.journalVolume(vol)
+	}
+	return nil
+}
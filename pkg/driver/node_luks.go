@@ -0,0 +1,105 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/mount"
+)
+
+// isEncrypted returns true if the storage class of the volume asked for
+// the volume to be encrypted
+func isEncrypted(volumeContext map[string]string) bool {
+	encrypted, _ := strconv.ParseBool(volumeContext[encryptedKey])
+	return encrypted
+}
+
+// openEncryptedDevice opens the LUKS device on top of the iSCSI disk of the
+// volume, formatting it on first use, and returns the path of the device to
+// be formatted and mounted
+func openEncryptedDevice(vol *apis.CStorVolumeAttachment, devicePath string, secrets map[string]string) (string, error) {
+	passphrase := secrets[encryptionPassphraseKey]
+	if passphrase == "" {
+		return "", status.Errorf(codes.InvalidArgument,
+			"missing %s in the node stage secrets of encrypted volume %s",
+			encryptionPassphraseKey, vol.Spec.Volume.Name)
+	}
+	// The scheduled trims of the filesystem have to reach the volume
+	allowDiscards := vol.Annotations[utils.TrimScheduleAnnotation] != ""
+	mapped, err := iscsiutils.OpenLUKS(devicePath, vol.Annotations[utils.LUKSDeviceAnnotation],
+		passphrase, allowDiscards)
+	if errors.Is(err, iscsiutils.ErrNotLUKSDevice) {
+		return "", status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return mapped, err
+}
+
+// verifyLUKSDevice returns an error if the LUKS device of an encrypted
+// volume is not open. It can't be opened again without the passphrase,
+// which is only passed on to NodeStageVolume.
+func verifyLUKSDevice(vol *apis.CStorVolumeAttachment) error {
+	name := vol.Annotations[utils.LUKSDeviceAnnotation]
+	if name == "" {
+		return nil
+	}
+	if _, err := os.Stat(iscsiutils.LUKSDevicePath(name)); err != nil {
+		return fmt.Errorf("LUKS device %s is not open, the volume has to be staged again", name)
+	}
+	return nil
+}
+
+// unmountAndDetachDisk unmounts the staging path of the volume, closes its
+// LUKS device if it is encrypted and logs out of the iSCSI session
+func unmountAndDetachDisk(vol *apis.CStorVolumeAttachment, stagingTargetPath string) error {
+	name := vol.Annotations[utils.LUKSDeviceAnnotation]
+	if name == "" {
+		return iscsiutils.UnmountAndDetachDisk(vol, stagingTargetPath)
+	}
+
+	// The LUKS device can't be closed while it is mounted
+	if stagingTargetPath != "" {
+		mounter := mount.New("")
+		notMnt, err := mounter.IsLikelyNotMountPoint(stagingTargetPath)
+		switch {
+		case iscsiutils.IsCorruptedMount(err):
+			if err := iscsiutils.ForceUnmount(stagingTargetPath); err != nil {
+				return err
+			}
+		case err != nil && !os.IsNotExist(err):
+			return err
+		case err == nil && !notMnt:
+			if err := mounter.Unmount(stagingTargetPath); err != nil {
+				return err
+			}
+		}
+	}
+	logrus.Infof("Volume %s: closing LUKS device %s", vol.Spec.Volume.Name, name)
+	if err := iscsiutils.CloseLUKS(name); err != nil {
+		return err
+	}
+	return iscsiutils.UnmountAndDetachDisk(vol, stagingTargetPath)
+}
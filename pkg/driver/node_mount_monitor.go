@@ -0,0 +1,376 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	informers "github.com/openebs/api/v3/pkg/client/informers/externalversions"
+	listers "github.com/openebs/api/v3/pkg/client/listers/cstor/v1"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/mount"
+)
+
+const (
+	// mountMonitorResyncPeriod is the interval at which all the volumes
+	// staged on the node are verified irrespective of mount notifications
+	mountMonitorResyncPeriod = 2 * time.Minute
+)
+
+// expectedMountMode returns the read-only or read-write mode option a
+// mount made with the given options is expected to have
+func expectedMountMode(options []string) string {
+	if utils.IsReadOnlyMount(options) {
+		return "ro"
+	}
+	return "rw"
+}
+
+func verifyMountOpts(opts []string, desiredOpt string) bool {
+	for _, opt := range opts {
+		if opt == desiredOpt {
+			return true
+		}
+	}
+	return false
+}
+
+// monitorMounts makes sure that all the volumes staged on this node are
+// mounted with the original mount options. The CVAs of the node are
+// watched through an informer and the mount table of the node through
+// change notifications on /proc/self/mountinfo, a volume is verified only
+// when its CVA or one of its mounts changes. All the volumes are verified
+// every mountMonitorResyncPeriod as a backstop for missed notifications.
+// This function blocks until the stop channel is closed therefore should be
+// run as a goroutine
+func (ns *node) monitorMounts(stopCh <-chan struct{}) {
+	factory, err := utils.NewCStorInformerFactory(0,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = utils.NODEID + "=" + utils.NodeIDENV
+		}),
+	)
+	if err != nil {
+		logrus.Errorf("failed to watch cva for mount monitoring: %v", err)
+		return
+	}
+	informer := factory.Cstor().V1().CStorVolumeAttachments()
+	mm := &mountMonitor{
+		ns:     ns,
+		lister: informer.Lister(),
+		queue:  workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "mount-monitor"),
+	}
+	defer mm.queue.ShutDown()
+
+	enqueue := func(obj interface{}) {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			mm.queue.Add(key)
+		}
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, newObj interface{}) { enqueue(newObj) },
+	})
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.Informer().HasSynced) {
+		logrus.Errorf("failed to sync cva cache for mount monitoring")
+		return
+	}
+
+	mountChanges, err := watchMountInfo(stopCh)
+	if err != nil {
+		// The resync keeps the volumes verified, although with a delay
+		logrus.Errorf("failed to watch mount table, relying on periodic resync: %v", err)
+	}
+	mm.refreshMounts()
+	go mm.run()
+
+	resync := time.NewTicker(mountMonitorResyncPeriod)
+	defer resync.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-mountChanges:
+			for _, path := range mm.refreshMounts() {
+				mm.enqueuePath(path)
+			}
+		case <-resync.C:
+			mm.refreshMounts()
+			vols, err := mm.lister.List(labels.Everything())
+			if err != nil {
+				continue
+			}
+			for _, vol := range vols {
+				enqueue(vol)
+			}
+		}
+	}
+}
+
+// mountMonitor holds the state shared between the event loop of the mount
+// monitor and the worker verifying the volumes
+type mountMonitor struct {
+	ns     *node
+	lister listers.CStorVolumeAttachmentLister
+	queue  workqueue.RateLimitingInterface
+
+	// lock protects mounts
+	lock sync.RWMutex
+	// mounts is the last observed mount table of the node
+	mounts map[string]mount.MountPoint
+}
+
+// refreshMounts reads the mount table of the node and returns the paths
+// whose mounts have appeared, disappeared or changed since the last read
+func (mm *mountMonitor) refreshMounts() []string {
+	mountList, err := mount.New("").List()
+	if err != nil {
+		logrus.Errorf("failed to list mounts: %v", err)
+		return nil
+	}
+	mounts := make(map[string]mount.MountPoint, len(mountList))
+	for _, mp := range mountList {
+		mounts[mp.Path] = mp
+	}
+
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	var changed []string
+	for path, mp := range mounts {
+		old, ok := mm.mounts[path]
+		if !ok || old.Device != mp.Device || !reflect.DeepEqual(old.Opts, mp.Opts) {
+			changed = append(changed, path)
+		}
+	}
+	for path := range mm.mounts {
+		if _, ok := mounts[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	mm.mounts = mounts
+	return changed
+}
+
+// enqueuePath queues the volumes which are mounted at the given path
+func (mm *mountMonitor) enqueuePath(path string) {
+	vols, err := mm.lister.List(labels.Everything())
+	if err != nil {
+		return
+	}
+	for _, vol := range vols {
+		if vol.Spec.Volume.StagingTargetPath == path || isPublishedAt(vol, path) {
+			if key, err := cache.MetaNamespaceKeyFunc(vol); err == nil {
+				mm.queue.Add(key)
+			}
+		}
+	}
+}
+
+// isPublishedAt returns true if the given path is one of the publish
+// targets of the volume
+func isPublishedAt(vol *apis.CStorVolumeAttachment, path string) bool {
+	for _, target := range utils.PublishTargets(vol) {
+		if target.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyBlock binds the LUN of a raw block volume at its publish paths
+// again if the device bound there is not the current device of the LUN
+func (mm *mountMonitor) verifyBlock(key string, vol *apis.CStorVolumeAttachment) {
+	if vol.Spec.Volume.DevicePath == "" {
+		mm.queue.Forget(key)
+		return
+	}
+	stale := staleBlockPublishes(vol)
+	if len(stale) == 0 {
+		mm.queue.Forget(key)
+		return
+	}
+	volumeID := vol.Spec.Volume.Name
+	if err := mm.ns.ops.start(volumeID, apis.CStorVolumeAttachmentStatusRemountUnderProgress); err != nil {
+		mm.queue.AddAfter(key, utils.MonitorMountRetryTimeout*time.Second)
+		return
+	}
+	go func(csivol apis.CStorVolumeAttachment) {
+		defer mm.ns.ops.done(volumeID)
+		if err := mm.ns.recoverBlockVolume(&csivol, stale); err != nil {
+			mm.queue.AddRateLimited(key)
+			return
+		}
+		mm.queue.Forget(key)
+	}(*vol.DeepCopy())
+}
+
+// run processes the queued volumes until the queue is shut down
+func (mm *mountMonitor) run() {
+	for {
+		key, shutdown := mm.queue.Get()
+		if shutdown {
+			return
+		}
+		mm.verify(key.(string))
+		mm.queue.Done(key)
+	}
+}
+
+// verify remounts the volume if its staging path or any of its publish
+// paths is not mounted or has lost its rw mount option
+func (mm *mountMonitor) verify(key string) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		mm.queue.Forget(key)
+		return
+	}
+	vol, err := mm.lister.CStorVolumeAttachments(namespace).Get(name)
+	if err != nil {
+		// The CVA has been deleted
+		mm.queue.Forget(key)
+		return
+	}
+	if vol.DeletionTimestamp != nil {
+		logrus.Infof("CVA: %v for volume: %v has been marked for cleanup activity", vol.Name, vol.Spec.Volume.Name)
+		err := mm.ns.cleanupVolume(vol.DeepCopy(), func(err error) {
+			if err != nil {
+				mm.queue.AddRateLimited(key)
+				return
+			}
+			mm.queue.Forget(key)
+		})
+		if err != nil {
+			// The volume is being operated upon by a CSI RPC, it is
+			// cleaned up once the operation is expected to be over
+			mm.queue.AddAfter(key, utils.MonitorMountRetryTimeout*time.Second)
+		}
+		return
+	}
+	if vol.Spec.Volume.AccessType == "block" {
+		mm.verifyBlock(key, vol)
+		return
+	}
+	// This check is added to avoid monitoring volume if it has not
+	// been mounted yet. Although CStorVolumeAttachment CR gets created at
+	// ControllerPublish step.
+	targets := utils.PublishTargets(vol)
+	if vol.Spec.Volume.StagingTargetPath == "" || len(targets) == 0 {
+		mm.queue.Forget(key)
+		return
+	}
+
+	// The mounts have to be in the same read-only or read-write mode the
+	// volume was staged and published with, a filesystem which has been
+	// remounted read-only by the kernel on errors needs to be recovered
+	_, stagingOpts := utils.StagingMountOptions(vol)
+	mounted := map[string]bool{}
+	var broken []utils.PublishTarget
+	mm.lock.RLock()
+	stagingMountPoint, stagingPathExists := mm.mounts[vol.Spec.Volume.StagingTargetPath]
+	mounted[vol.Spec.Volume.StagingTargetPath] = stagingPathExists
+	stagingHealthy := stagingPathExists &&
+		verifyMountOpts(stagingMountPoint.Opts, expectedMountMode(stagingOpts))
+	for _, target := range targets {
+		targetMountPoint, targetPathExists := mm.mounts[target.Path]
+		mounted[target.Path] = targetPathExists
+		if !targetPathExists ||
+			!verifyMountOpts(targetMountPoint.Opts, expectedMountMode(target.MountOptions())) {
+			broken = append(broken, target)
+		}
+	}
+	mm.lock.RUnlock()
+	if stagingHealthy && len(broken) == 0 {
+		mm.queue.Forget(key)
+		return
+	}
+
+	// The volume is being operated upon by a CSI RPC, it is verified again
+	// once the operation is expected to be over
+	volumeID := vol.Spec.Volume.Name
+	if err := mm.ns.ops.start(volumeID, apis.CStorVolumeAttachmentStatusRemountUnderProgress); err != nil {
+		mm.queue.AddAfter(key, utils.MonitorMountRetryTimeout*time.Second)
+		return
+	}
+	// Remounts are run in parallel so that a slow volume does not delay
+	// the others
+	go func(csivol apis.CStorVolumeAttachment) {
+		defer mm.ns.ops.done(volumeID)
+		// Every publish is a bind mount of the staging mount, all of them
+		// have to be bound again once the staging path is remounted
+		if !stagingHealthy {
+			broken = nil
+		}
+		logrus.Infof("Remounting vol: %s, staging path: %v, publish paths: %v",
+			volumeID, !stagingHealthy, broken)
+		var remountStaging func(*apis.CStorVolumeAttachment) error
+		if !stagingHealthy {
+			remountStaging = mm.ns.restoreStagingMount
+		}
+		if err := utils.RemountVolume(&csivol, remountStaging, broken, mounted); err != nil {
+			logrus.Errorf("Remount failed for vol: %s : err: %v", volumeID, err)
+			mm.queue.AddRateLimited(key)
+			return
+		}
+		logrus.Infof("Remount successful for vol: %s", volumeID)
+		mm.queue.Forget(key)
+	}(*vol.DeepCopy())
+}
+
+// cleanupVolume unmounts and detaches the given volume in the background
+// and removes the finalizer from its CVA. An error is returned if the
+// volume is busy, otherwise the outcome of the cleanup is passed to done.
+func (ns *node) cleanupVolume(vol *apis.CStorVolumeAttachment, done func(error)) error {
+	if err := ns.ops.start(vol.Spec.Volume.Name, apis.CStorVolumeAttachmentStatusUnmountUnderProgress); err != nil {
+		logrus.Infof("Skipping cleanup: %v", err)
+		return err
+	}
+	logrus.Infof("Volume: %v marked as %v as part of cleanup activity",
+		vol.Spec.Volume.Name, apis.CStorVolumeAttachmentStatusUnmountUnderProgress)
+	// This is being run in a go routine so that if unmount and detach
+	// commands take time, the monitor is not delayed
+	go func(vol *apis.CStorVolumeAttachment) {
+		defer ns.ops.done(vol.Spec.Volume.Name)
+		err := ns.detachVolume(vol)
+		if err != nil {
+			logrus.Errorf(err.Error())
+		}
+		done(err)
+	}(vol)
+	return nil
+}
+
+// detachVolume unmounts and detaches the volume whose CVA is being deleted
+// and removes the finalizer from the CVA
+func (ns *node) detachVolume(vol *apis.CStorVolumeAttachment) error {
+	logrus.Infof("Cleaning up %s from node", vol.Spec.Volume.Name)
+	if err := unmountAndDetachDisk(vol, vol.Spec.Volume.StagingTargetPath); err != nil {
+		return err
+	}
+	ns.forgetVolume(vol.Spec.Volume.Name)
+	vol.Finalizers = nil
+	logrus.Infof("Cleaning up cva %s", vol.Name)
+	_, err := utils.UpdateCStorVolumeAttachmentCR(vol)
+	return err
+}
@@ -0,0 +1,231 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// portalWatchResyncPeriod is the interval at which all the
+	// CStorVolumes are replayed to the portal watcher, this takes care of
+	// portal changes which could not be handled when they were observed
+	portalWatchResyncPeriod = 5 * time.Minute
+
+	reasonTargetPortalChanged      = "TargetPortalChanged"
+	reasonTargetPortalSwitchFailed = "TargetPortalSwitchFailed"
+)
+
+// watchTargetPortals watches the CStorVolumes and moves the iSCSI sessions
+// of the volumes staged on this node over to the new target portal whenever
+// the target service or pod IP changes. This function blocks until the
+// stop channel is closed therefore should be run as a goroutine
+func (ns *node) watchTargetPortals(stopCh <-chan struct{}) {
+	factory, err := utils.NewCStorInformerFactory(portalWatchResyncPeriod)
+	if err != nil {
+		logrus.Errorf("failed to watch cstorvolumes for target portal changes: %v", err)
+		return
+	}
+	informer := factory.Cstor().V1().CStorVolumes().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldCV, ok := oldObj.(*apis.CStorVolume)
+			if !ok {
+				return
+			}
+			newCV, ok := newObj.(*apis.CStorVolume)
+			if !ok {
+				return
+			}
+			// Resyncs are delivered as updates with the same resource
+			// version, they are verified as well in case a previous switch
+			// over failed
+			if oldCV.Spec.TargetPortal == newCV.Spec.TargetPortal &&
+				oldCV.ResourceVersion != newCV.ResourceVersion {
+				return
+			}
+			ns.verifyTargetPortal(newCV)
+		},
+	})
+	factory.Start(stopCh)
+	<-stopCh
+}
+
+// verifyTargetPortal compares the target portal of the CStorVolume against
+// the portal the volume is logged in to on this node
+func (ns *node) verifyTargetPortal(cv *apis.CStorVolume) {
+	if cv.Spec.TargetPortal == "" {
+		return
+	}
+	vol, err := utils.GetCStorVolumeAttachment(cv.Name + "-" + utils.NodeIDENV)
+	if err != nil {
+		if !k8serror.IsNotFound(err) {
+			logrus.Errorf("failed to get cva for volume %s: %v", cv.Name, err)
+		}
+		return
+	}
+	// Volumes which are not staged pick up the latest portal from the
+	// CStorVolume during NodeStageVolume
+	if vol.DeletionTimestamp != nil || len(vol.Finalizers) == 0 ||
+		vol.Spec.ISCSI.TargetPortal == cv.Spec.TargetPortal {
+		return
+	}
+
+	volumeID := vol.Spec.Volume.Name
+	if err := ns.ops.start(volumeID, apis.CStorVolumeAttachmentStatusRemountUnderProgress); err != nil {
+		// The change will be picked up again on the next resync
+		logrus.Infof("Skipping target portal switch over: %v", err)
+		return
+	}
+	go func() {
+		defer ns.ops.done(volumeID)
+		oldPortal := vol.Spec.ISCSI.TargetPortal
+		logrus.Infof("Target portal of volume %s moved from %s to %s",
+			volumeID, oldPortal, cv.Spec.TargetPortal)
+		if err := ns.switchTargetPortal(vol, cv); err != nil {
+			logrus.Errorf("failed to switch volume %s over to portal %s: %v",
+				volumeID, cv.Spec.TargetPortal, err)
+			utils.RecordCVAEvent(vol, corev1.EventTypeWarning, reasonTargetPortalSwitchFailed,
+				"failed to switch over from portal %s to %s: %v", oldPortal, cv.Spec.TargetPortal, err)
+			return
+		}
+		utils.RecordCVAEvent(vol, corev1.EventTypeNormal, reasonTargetPortalChanged,
+			"switched over from portal %s to %s", oldPortal, cv.Spec.TargetPortal)
+	}()
+}
+
+// switchTargetPortal logs in to the new portal of the volume, moves the
+// mounts over to the new device, logs out of the old portal and finally
+// records the new portal and device on the CVA
+func (ns *node) switchTargetPortal(vol *apis.CStorVolumeAttachment, cv *apis.CStorVolume) error {
+	volumeID := vol.Spec.Volume.Name
+	oldPortal := vol.Spec.ISCSI.TargetPortal
+	newPortal := cv.Spec.TargetPortal
+
+	// The LUKS device is bound to the disk of the old session
+	if name := vol.Annotations[utils.LUKSDeviceAnnotation]; name != "" {
+		return fmt.Errorf("LUKS device %s can't be moved over, the volume has to be staged again", name)
+	}
+	if reachable, err := utils.IsVolumeReachable(volumeID, newPortal); !reachable {
+		return fmt.Errorf("new portal %s is not reachable: %v", newPortal, err)
+	}
+
+	newVol := vol.DeepCopy()
+	newVol.Spec.ISCSI.TargetPortal = newPortal
+	newVol.Spec.ISCSI.Iqn = cv.Spec.Iqn
+	devicePath, err := ns.attachDisk(newVol)
+	if err != nil {
+		return err
+	}
+
+	// A multipath device stays the same when one of its paths goes away,
+	// otherwise the login creates a new disk and the mounts which are still
+	// on the disk of the old session have to be moved over
+	newVol.Spec.Volume.DevicePath = getISCSIByPath(newPortal, newVol.Spec.ISCSI.Iqn)
+	if strings.HasPrefix(devicePath, "/dev/dm-") {
+		newVol.Spec.Volume.DevicePath = devicePath
+	} else if err := ns.moveMounts(newVol); err != nil {
+		// Mounts of the old device may still be in place, its session is
+		// kept and the switch is retried on the next resync
+		return err
+	}
+
+	if err := iscsiutils.LogoutSession(vol.Spec.ISCSI.Iqn, oldPortal); err != nil {
+		logrus.Errorf("failed to logout of old portal %s of volume %s: %v", oldPortal, volumeID, err)
+	}
+
+	vol.Spec.ISCSI.TargetPortal = newVol.Spec.ISCSI.TargetPortal
+	vol.Spec.ISCSI.Iqn = newVol.Spec.ISCSI.Iqn
+	vol.Spec.Volume.DevicePath = newVol.Spec.Volume.DevicePath
+	_, err = utils.UpdateCStorVolumeAttachmentCR(vol)
+	return err
+}
+
+// moveMounts remounts the staging and publish paths of the volume from the
+// device recorded in the given CVA. Nothing is mounted unless every path has
+// been unmounted, the mounts of the old device would otherwise stay in use
+// underneath the new ones.
+func (ns *node) moveMounts(vol *apis.CStorVolumeAttachment) error {
+	devicePath := vol.Spec.Volume.DevicePath
+	targets := utils.PublishTargets(vol)
+	var paths []string
+	for _, target := range targets {
+		paths = append(paths, target.Path)
+	}
+
+	// The device of a raw block volume is bound directly at the targets
+	source := devicePath
+	staging := ""
+	if vol.Spec.Volume.AccessType != "block" {
+		staging = vol.Spec.Volume.StagingTargetPath
+		if staging == "" {
+			return nil
+		}
+		// The publish paths are bind mounts of the staging path, they go
+		// first
+		paths = append(paths, staging)
+		source = staging
+	}
+	for _, path := range paths {
+		if err := ns.unmountForMove(path); err != nil {
+			return err
+		}
+	}
+
+	if staging != "" {
+		fsType, options := utils.StagingMountOptions(vol)
+		if err := ns.mounter.Mount(devicePath, staging, fsType, options); err != nil {
+			return err
+		}
+	}
+	for _, target := range targets {
+		if err := ns.mounter.Mount(source, target.Path, "", target.MountOptions()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmountForMove unmounts the given path and makes sure that nothing is
+// left mounted there, a path which is not mounted is left as is
+func (ns *node) unmountForMove(path string) error {
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(path)
+	if os.IsNotExist(err) || (err == nil && notMnt) {
+		return nil
+	}
+	if err == nil {
+		err = ns.mounter.Unmount(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to unmount %s: %v", path, err)
+	}
+	if notMnt, err = ns.mounter.IsLikelyNotMountPoint(path); err != nil || !notMnt {
+		return fmt.Errorf("%s is still mounted after unmount: %v", path, err)
+	}
+	return nil
+}
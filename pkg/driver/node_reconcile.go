@@ -0,0 +1,258 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// reconcileWorkers is the number of volumes reconciled in parallel
+	// on startup
+	reconcileWorkers = 4
+
+	reasonNodeReconciled      = "NodeReconciled"
+	reasonNodeReconcileFailed = "NodeReconcileFailed"
+)
+
+// reconcileNode brings the volumes of this node in line with their CVAs
+// after the plugin restarts. Once the journal is replayed, the sessions
+// which no longer belong to any CVA are logged out as per the session GC
+// mode. Every CVA is then compared with the iSCSI sessions, devices and
+// mounts on the node:
+//   - volumes whose CVA is being deleted are unmounted and logged out
+//   - sessions left behind by a failed stage are logged out
+//   - broken sessions of staged volumes are logged in again
+//   - staging and publish mounts which are missing are mounted again, a
+//     stage interrupted before the format is completed
+//
+// Each fix-up is reported as an event on the CVA. The volumes are
+// reconciled by a bounded number of workers, RPCs on a volume being
+// reconciled are rejected with Aborted and retried by kubelet. Last, the
+// ephemeral volumes whose pod is gone are deleted.
+func (ns *node) reconcileNode() {
+	ns.reconcileJournal()
+	if err := cleanupStaleISCSISessions(ns.driver.config.ISCSISessionGC); err != nil {
+		logrus.Errorf("failed to cleanup stale iSCSI sessions: %v", err)
+	}
+
+	// The sessions are listed before the CVAs, a session in the list
+	// belongs to a stage which had already set the finalizer on its CVA
+	sessions, err := iscsiutils.ListSessions()
+	if err != nil {
+		logrus.Errorf("Failed to reconcile node, failed to list iSCSI sessions: %v", err)
+		return
+	}
+	csivolList, err := listNodeVolumes()
+	if err != nil {
+		logrus.Errorf("Failed to get cva list, err: %v", err)
+		return
+	}
+	mountPoints, err := ns.mounter.List()
+	if err != nil {
+		logrus.Errorf("Failed to reconcile node, failed to list mounts: %v", err)
+		return
+	}
+	mounted := map[string]bool{}
+	for _, mp := range mountPoints {
+		mounted[mp.Path] = true
+	}
+
+	var (
+		lock           sync.Mutex
+		fixed, failed  int
+		volumes        = csivolList.Items
+		reconcileStart = time.Now()
+	)
+	workqueue.ParallelizeUntil(context.Background(), reconcileWorkers, len(volumes), func(i int) {
+		fixes, err := ns.reconcileVolume(&volumes[i], sessions, mounted)
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			failed++
+		} else if len(fixes) != 0 {
+			fixed++
+		}
+	})
+	logrus.Infof("Reconciled %d volumes in %v: %d fixed, %d failed",
+		len(volumes), time.Since(reconcileStart).Round(time.Millisecond), fixed, failed)
+
+	ns.cleanupEphemeralVolumes()
+}
+
+// listNodeVolumes lists the CVAs of this node, retrying for a while since
+// the API server may not be reachable yet when the node boots
+func listNodeVolumes() (*apis.CStorVolumeAttachmentList, error) {
+	var err error
+	for count := 0; count < 5; count++ {
+		var csivolList *apis.CStorVolumeAttachmentList
+		if csivolList, err = utils.GetVolListForNode(); err == nil {
+			return csivolList, nil
+		}
+		time.Sleep(time.Second)
+	}
+	return nil, err
+}
+
+// reconcileVolume reconciles a single volume and reports the fix-ups done
+func (ns *node) reconcileVolume(
+	vol *apis.CStorVolumeAttachment,
+	sessions []iscsiutils.Session,
+	mounted map[string]bool,
+) ([]string, error) {
+	volumeID := vol.Spec.Volume.Name
+	state := apis.CStorVolumeAttachmentStatusRemountUnderProgress
+	if vol.DeletionTimestamp != nil {
+		state = apis.CStorVolumeAttachmentStatusUnmountUnderProgress
+	}
+	if err := ns.ops.start(volumeID, state); err != nil {
+		logrus.Infof("Skipping reconcile: %v", err)
+		return nil, nil
+	}
+	defer ns.ops.done(volumeID)
+
+	var (
+		fixes []string
+		err   error
+	)
+	if vol.DeletionTimestamp != nil {
+		if err = ns.detachVolume(vol); err == nil {
+			fixes = append(fixes, "unmounted and logged out of the deleted volume")
+		}
+	} else {
+		fixes, err = ns.reconcileStage(vol, sessions, mounted)
+	}
+
+	switch {
+	case err != nil:
+		logrus.Errorf("Volume %s: failed to reconcile after %v: %v", volumeID, fixes, err)
+		utils.RecordCVAEvent(vol, corev1.EventTypeWarning, reasonNodeReconcileFailed,
+			"failed to reconcile volume on node %s: %v", utils.NodeIDENV, err)
+	case len(fixes) != 0:
+		logrus.Infof("Volume %s: reconciled: %s", volumeID, strings.Join(fixes, "; "))
+		utils.RecordCVAEvent(vol, corev1.EventTypeNormal, reasonNodeReconciled,
+			"reconciled volume on node %s: %s", utils.NodeIDENV, strings.Join(fixes, "; "))
+	}
+	return fixes, err
+}
+
+// reconcileStage finishes the stage and publish of a staged volume, or
+// rolls back the login of a volume whose stage failed
+func (ns *node) reconcileStage(
+	vol *apis.CStorVolumeAttachment,
+	sessions []iscsiutils.Session,
+	mounted map[string]bool,
+) ([]string, error) {
+	var fixes []string
+	if vol.Spec.ISCSI.Iqn == "" {
+		return nil, nil
+	}
+	problem, session := diagnoseISCSISession(vol, sessions)
+
+	// The finalizer is removed if the stage fails after the login
+	if len(vol.Finalizers) == 0 || vol.Spec.Volume.StagingTargetPath == "" {
+		if session == nil {
+			return nil, nil
+		}
+		inUse, err := session.InUseDevices()
+		if err != nil {
+			return nil, err
+		}
+		if len(inUse) != 0 {
+			return nil, fmt.Errorf("devices %v of the unstaged volume are in use", inUse)
+		}
+		if err := iscsiutils.LogoutSession(session.TargetIQN, session.PersistentPortal); err != nil {
+			return nil, err
+		}
+		return append(fixes, "logged out of the session left behind by a failed stage"), nil
+	}
+
+	if problem != sessionHealthy {
+		if err := ns.repairISCSISession(vol, problem, session); err != nil {
+			return fixes, fmt.Errorf("failed to recover %s iSCSI session: %v", problem, err)
+		}
+		fixes = append(fixes, fmt.Sprintf("recovered %s iSCSI session", problem))
+	}
+	if !waitForDevice(vol.Spec.Volume.DevicePath, blockDeviceWaitTimeout) {
+		return fixes, fmt.Errorf("device %s is missing", vol.Spec.Volume.DevicePath)
+	}
+	if err := verifyLUKSDevice(vol); err != nil {
+		return fixes, err
+	}
+
+	if vol.Spec.Volume.AccessType == "block" {
+		for _, sp := range staleBlockPublishes(vol) {
+			if err := ns.rebindBlockDevice(vol, []utils.PublishTarget{sp.target}); err != nil {
+				return fixes, err
+			}
+			fixes = append(fixes, fmt.Sprintf("rebound block device at %s", sp))
+		}
+		return fixes, nil
+	}
+
+	staging := vol.Spec.Volume.StagingTargetPath
+	if !mounted[staging] {
+		if err := os.MkdirAll(staging, 0750); err != nil {
+			return fixes, err
+		}
+		// The device is formatted only if it has no filesystem yet, which
+		// is the case if the stage was interrupted after the login
+		if err := ns.restoreStagingMount(vol); err != nil {
+			return fixes, err
+		}
+		fixes = append(fixes, fmt.Sprintf("mounted staging path %s", staging))
+	}
+
+	var dropped []string
+	for _, target := range utils.PublishTargets(vol) {
+		if mounted[target.Path] {
+			continue
+		}
+		if _, err := os.Stat(target.Path); os.IsNotExist(err) {
+			// kubelet has removed the publish path, the publish was
+			// rolled back or the pod is gone
+			dropped = append(dropped, target.Path)
+			continue
+		}
+		if err := ns.mounter.Mount(staging, target.Path, "", target.MountOptions()); err != nil {
+			return fixes, err
+		}
+		fixes = append(fixes, fmt.Sprintf("published at %s", target.Path))
+	}
+	if len(dropped) == 0 {
+		return fixes, nil
+	}
+	for _, path := range dropped {
+		utils.RemovePublishTarget(vol, path)
+	}
+	if _, err := utils.UpdateCStorVolumeAttachmentCR(vol); err != nil {
+		return fixes, err
+	}
+	return append(fixes, fmt.Sprintf("dropped publish paths %v removed by kubelet", dropped)), nil
+}
@@ -0,0 +1,98 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// sessionGCEnabled logs out of the stale iSCSI sessions on startup
+	sessionGCEnabled = "enabled"
+	// sessionGCDryRun only reports the stale iSCSI sessions on startup
+	sessionGCDryRun = "dry-run"
+	// sessionGCDisabled leaves the iSCSI sessions on the host as is
+	sessionGCDisabled = "disabled"
+)
+
+// cleanupStaleISCSISessions logs out of the sessions to cStor targets which
+// are not referred to by any CVA of this node. These are left behind when
+// a CVA is deleted while the node is down or when the driver crashes
+// between the login and the creation of the CVA. Sessions whose disks are
+// still mounted or held by other devices are reported and left as is.
+func cleanupStaleISCSISessions(mode string) error {
+	switch mode {
+	case sessionGCDisabled:
+		return nil
+	case sessionGCEnabled, sessionGCDryRun:
+	default:
+		return fmt.Errorf("invalid iSCSI session gc mode %q", mode)
+	}
+
+	sessions, err := iscsiutils.ListSessions()
+	if err != nil {
+		return err
+	}
+	// The CVAs have to be listed successfully, otherwise all the sessions
+	// would be considered stale
+	csivolList, err := utils.GetVolListForNode()
+	if err != nil {
+		return fmt.Errorf("failed to list cva for node %s: %v", utils.NodeIDENV, err)
+	}
+	known := map[string]bool{}
+	for _, vol := range csivolList.Items {
+		known[vol.Spec.Volume.Name] = true
+		if vol.Spec.ISCSI.Iqn != "" {
+			known[vol.Spec.ISCSI.Iqn] = true
+		}
+	}
+
+	for i := range sessions {
+		session := &sessions[i]
+		volName := session.VolumeName()
+		if volName == "" || known[volName] || known[session.TargetIQN] {
+			continue
+		}
+		inUse, err := session.InUseDevices()
+		if err != nil {
+			logrus.Errorf("failed to verify usage of stale iSCSI session %s to %s: %v",
+				session.SID, session.TargetIQN, err)
+			continue
+		}
+		if len(inUse) != 0 {
+			logrus.Warningf("Skipping stale iSCSI session %s to %s at %s, devices %v are in use",
+				session.SID, session.TargetIQN, session.PersistentPortal, inUse)
+			continue
+		}
+		if mode == sessionGCDryRun {
+			logrus.Infof("Found stale iSCSI session %s to %s at %s, not logging out in %s mode",
+				session.SID, session.TargetIQN, session.PersistentPortal, mode)
+			continue
+		}
+		logrus.Infof("Logging out of stale iSCSI session %s to %s at %s",
+			session.SID, session.TargetIQN, session.PersistentPortal)
+		if err := iscsiutils.LogoutSession(session.TargetIQN, session.PersistentPortal); err != nil {
+			logrus.Errorf("failed to logout of stale iSCSI session %s to %s: %v",
+				session.SID, session.TargetIQN, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// sessionProblem describes the state of the iSCSI session of a volume
+// as observed by the session monitor
+type sessionProblem string
+
+const (
+	// sessionHealthy indicates that the session is logged in and all its
+	// devices are running
+	sessionHealthy sessionProblem = "Healthy"
+	// sessionLoggedOut indicates that there is no session to the target
+	// although the volume is staged on the node
+	sessionLoggedOut sessionProblem = "LoggedOut"
+	// sessionFailed indicates that the kernel has lost the connection to
+	// the target
+	sessionFailed sessionProblem = "Failed"
+	// sessionStalePortal indicates that the session is logged in to a
+	// portal other than the one the volume is served from
+	sessionStalePortal sessionProblem = "StalePortal"
+	// sessionDeviceOffline indicates that the session is logged in but
+	// the attached disk is not able to serve IOs
+	sessionDeviceOffline sessionProblem = "DeviceOffline"
+)
+
+const (
+	reasonISCSISessionUnhealthy      = "ISCSISessionUnhealthy"
+	reasonISCSISessionRecovered      = "ISCSISessionRecovered"
+	reasonISCSISessionRecoveryFailed = "ISCSISessionRecoveryFailed"
+)
+
+// monitorISCSISessions periodically verifies the iSCSI sessions of all the
+// volumes staged on this node and recovers the ones which are not healthy.
+// This function runs a never ending loop therefore should be run as a
+// goroutine
+func (ns *node) monitorISCSISessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ns.verifyISCSISessions()
+	}
+}
+
+// verifyISCSISessions matches the sessions present on the host against the
+// CVAs owned by this node. Each unhealthy session is recovered in its own
+// goroutine so that a slow login does not delay the others.
+func (ns *node) verifyISCSISessions() {
+	sessions, err := iscsiutils.ListSessions()
+	if err != nil {
+		logrus.Errorf("failed to list iSCSI sessions: %v", err)
+		return
+	}
+	csivolList, err := utils.GetVolListForNode()
+	if err != nil {
+		logrus.Errorf("failed to list cva for node %s: %v", utils.NodeIDENV, err)
+		return
+	}
+	for _, vol := range csivolList.Items {
+		if vol.DeletionTimestamp != nil {
+			continue
+		}
+		// The finalizer is set only once NodeStage starts the iSCSI login
+		// and is removed on unstage or on a failed stage, volumes without
+		// it are not expected to have a session
+		if len(vol.Finalizers) == 0 || vol.Spec.ISCSI.Iqn == "" {
+			continue
+		}
+		problem, session := diagnoseISCSISession(&vol, sessions)
+		if problem == sessionHealthy {
+			continue
+		}
+		volumeID := vol.Spec.Volume.Name
+		err := ns.ops.start(volumeID, apis.CStorVolumeAttachmentStatusRemountUnderProgress)
+		if err != nil {
+			// The volume is being operated upon by a CSI RPC, it will be
+			// verified again on the next tick
+			logrus.Infof("Skipping iSCSI session recovery: %v", err)
+			continue
+		}
+		go func(vol apis.CStorVolumeAttachment) {
+			defer ns.ops.done(vol.Spec.Volume.Name)
+			ns.recoverISCSISession(&vol, problem, session)
+		}(vol)
+	}
+}
+
+// diagnoseISCSISession returns the state of the session logged in to the
+// target of the given volume along with the session itself if present
+func diagnoseISCSISession(
+	vol *apis.CStorVolumeAttachment,
+	sessions []iscsiutils.Session,
+) (sessionProblem, *iscsiutils.Session) {
+	var session *iscsiutils.Session
+	for i := range sessions {
+		if sessions[i].TargetIQN != vol.Spec.ISCSI.Iqn {
+			continue
+		}
+		session = &sessions[i]
+		if session.HasPortal(vol.Spec.ISCSI.TargetPortal) {
+			break
+		}
+	}
+	switch {
+	case session == nil:
+		return sessionLoggedOut, nil
+	case !session.HasPortal(vol.Spec.ISCSI.TargetPortal):
+		return sessionStalePortal, session
+	case session.IsFailed() || !session.IsLoggedIn():
+		return sessionFailed, session
+	case len(session.OfflineDevices()) != 0:
+		return sessionDeviceOffline, session
+	}
+	return sessionHealthy, session
+}
+
+// recoverISCSISession attempts to bring the session of the volume back to
+// a healthy state and records the outcome on the CVA and as an event
+func (ns *node) recoverISCSISession(
+	vol *apis.CStorVolumeAttachment,
+	problem sessionProblem,
+	session *iscsiutils.Session,
+) {
+	volumeID := vol.Spec.Volume.Name
+	logrus.Warningf("iSCSI session of volume %s is %s, attempting recovery", volumeID, problem)
+	utils.RecordCVAEvent(vol, corev1.EventTypeWarning, reasonISCSISessionUnhealthy,
+		"iSCSI session of volume %s is %s on node %s", volumeID, problem, utils.NodeIDENV)
+
+	state, result := sessionHealthy, "recovered"
+	if err := ns.repairISCSISession(vol, problem, session); err != nil {
+		state, result = problem, fmt.Sprintf("failed: %v", err)
+		logrus.Errorf("iSCSI session recovery failed for volume %s: %v", volumeID, err)
+		utils.RecordCVAEvent(vol, corev1.EventTypeWarning, reasonISCSISessionRecoveryFailed,
+			"failed to recover %s iSCSI session: %v", problem, err)
+	} else {
+		logrus.Infof("iSCSI session recovery successful for volume %s", volumeID)
+		utils.RecordCVAEvent(vol, corev1.EventTypeNormal, reasonISCSISessionRecovered,
+			"recovered %s iSCSI session", problem)
+	}
+
+	annotations := map[string]string{
+		utils.ISCSISessionStateAnnotation: string(state),
+		utils.ISCSISessionRecoveryAnnotation: fmt.Sprintf("%s %s: %s",
+			time.Now().UTC().Format(time.RFC3339), problem, result),
+	}
+	if err := utils.UpdateCStorVolumeAttachmentAnnotations(vol.Name, annotations); err != nil {
+		logrus.Errorf("failed to record iSCSI session state on cva %s: %v", vol.Name, err)
+	}
+}
+
+// repairISCSISession rescans the session if only its devices are offline,
+// otherwise it logs out of the broken session if any and logs in again
+func (ns *node) repairISCSISession(
+	vol *apis.CStorVolumeAttachment,
+	problem sessionProblem,
+	session *iscsiutils.Session,
+) error {
+	volumeID := vol.Spec.Volume.Name
+	if problem == sessionDeviceOffline {
+		return iscsiutils.RescanSession(session.SID)
+	}
+
+	// There is no point in triggering iSCSI logins until the target is
+	// ready to serve IOs and reachable from this node
+	if ready, err := utils.IsVolumeReady(volumeID); err != nil || !ready {
+		return fmt.Errorf("volume %s is not ready", volumeID)
+	}
+	if reachable, err := utils.IsVolumeReachable(volumeID, vol.Spec.ISCSI.TargetPortal); !reachable {
+		return fmt.Errorf("volume %s is not reachable: %v", volumeID, err)
+	}
+
+	if session != nil {
+		// The kernel keeps retrying a failed or stale session, it needs to
+		// be logged out before logging in to the target again
+		if err := iscsiutils.LogoutSession(vol.Spec.ISCSI.Iqn, session.PersistentPortal); err != nil {
+			return err
+		}
+	}
+	_, err := ns.attachDisk(vol)
+	return err
+}
@@ -0,0 +1,146 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"time"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// trimSchedulerInterval is the interval at which the volumes due for
+	// a trim are looked up
+	trimSchedulerInterval = time.Minute
+
+	reasonVolumeTrimmed    = "VolumeTrimmed"
+	reasonVolumeTrimFailed = "VolumeTrimFailed"
+)
+
+// scheduleTrims periodically trims the filesystems of the volumes staged on
+// this node as set by their trim schedule, or right away if asked for
+// through the TrimRequestedAnnotation of their CVA, with at most the given
+// number of trims running at once. Volumes mounted with the discard option
+// release their blocks as they are freed and need no schedule.
+// This function runs a never ending loop therefore should be run as a
+// goroutine
+func (ns *node) scheduleTrims(maxConcurrent int) {
+	slots := make(chan struct{}, maxConcurrent)
+	ticker := time.NewTicker(trimSchedulerInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ns.startDueTrims(slots)
+	}
+}
+
+// startDueTrims starts the trim of the volumes which are due for one as
+// long as there are free slots, the others wait for the next tick
+func (ns *node) startDueTrims(slots chan struct{}) {
+	csivolList, err := utils.GetVolListForNode()
+	if err != nil {
+		logrus.Errorf("failed to list cva for node %s: %v", utils.NodeIDENV, err)
+		return
+	}
+	now := time.Now()
+	for _, vol := range csivolList.Items {
+		if !isTrimDue(&vol, now) {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			return
+		}
+		// The trim runs in the background, the CSI RPCs on the volume
+		// are not held off by it but cancel it
+		volumeID := vol.Spec.Volume.Name
+		ctx, err := ns.ops.startBackground(volumeID)
+		if err != nil {
+			// The volume is in transition, it is trimmed on a later tick
+			logrus.Infof("Skipping trim: %v", err)
+			<-slots
+			continue
+		}
+		go func(vol apis.CStorVolumeAttachment) {
+			defer func() { <-slots }()
+			defer ns.ops.doneBackground(vol.Spec.Volume.Name)
+			trimVolume(ctx, &vol)
+		}(vol)
+	}
+}
+
+// isTrimDue returns true if the filesystem of the staged volume has been
+// asked to be trimmed or if its last trim is older than its trim schedule
+func isTrimDue(vol *apis.CStorVolumeAttachment, now time.Time) bool {
+	if vol.DeletionTimestamp != nil || len(vol.Finalizers) == 0 ||
+		vol.Spec.Volume.StagingTargetPath == "" || vol.Spec.Volume.AccessType == "block" ||
+		utils.IsReadOnlyAttachment(vol) {
+		return false
+	}
+	// The LUKS device of an encrypted volume only passes discards on if
+	// the volume has a trim schedule
+	if vol.Annotations[utils.LUKSDeviceAnnotation] != "" &&
+		vol.Annotations[utils.TrimScheduleAnnotation] == "" {
+		return false
+	}
+	if vol.Annotations[utils.TrimRequestedAnnotation] != "" {
+		return true
+	}
+	// Schedules shorter than the scheduler interval are refused on
+	// provisioning, they are ignored if set on the CVA
+	interval, err := time.ParseDuration(vol.Annotations[utils.TrimScheduleAnnotation])
+	if err != nil || interval < trimSchedulerInterval {
+		return false
+	}
+	// The first trim is counted from the creation of the CVA
+	last := vol.CreationTimestamp.Time
+	if at, err := time.Parse(time.RFC3339, vol.Annotations[utils.LastTrimAnnotation]); err == nil {
+		last = at
+	}
+	return now.Sub(last) >= interval
+}
+
+// trimVolume trims the filesystem mounted at the staging path of the volume
+// and records the outcome on its CVA. A trim cancelled by an operation on
+// the volume is not recorded, it is done again on a later tick.
+func trimVolume(ctx context.Context, vol *apis.CStorVolumeAttachment) {
+	volumeID := vol.Spec.Volume.Name
+	start := time.Now()
+	trimmed, err := iscsiutils.Trim(ctx, vol.Spec.Volume.StagingTargetPath)
+	if ctx.Err() != nil {
+		logrus.Infof("Volume %s: trim interrupted by an operation on the volume", volumeID)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Volume %s: trim failed: %v", volumeID, err)
+		utils.RecordCVAEvent(vol, corev1.EventTypeWarning, reasonVolumeTrimFailed,
+			"failed to trim volume on node %s: %v", utils.NodeIDENV, err)
+	} else {
+		logrus.Infof("Volume %s: trimmed %d bytes in %v", volumeID, trimmed,
+			time.Since(start).Round(time.Millisecond))
+		utils.RecordCVAEvent(vol, corev1.EventTypeNormal, reasonVolumeTrimmed,
+			"trimmed %d bytes on node %s", trimmed, utils.NodeIDENV)
+	}
+	if uerr := utils.RecordVolumeTrim(vol.Name, start, trimmed, err); uerr != nil {
+		logrus.Errorf("failed to record trim on cva %s: %v", vol.Name, uerr)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	utils "github.com/openebs/cstor-csi/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsTrimDue(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	newVol := func(annotations map[string]string) *apis.CStorVolumeAttachment {
+		vol := &apis.CStorVolumeAttachment{}
+		vol.CreationTimestamp = metav1.NewTime(now.Add(-48 * time.Hour))
+		vol.Finalizers = []string{"node-1"}
+		vol.Annotations = annotations
+		vol.Spec.Volume.StagingTargetPath = "/var/lib/kubelet/staging"
+		return vol
+	}
+	lastTrim := now.Add(-2 * time.Hour).Format(time.RFC3339)
+	tests := map[string]struct {
+		vol  *apis.CStorVolumeAttachment
+		want bool
+	}{
+		"no schedule": {vol: newVol(nil)},
+		"schedule due": {
+			vol: newVol(map[string]string{
+				utils.TrimScheduleAnnotation: "1h",
+				utils.LastTrimAnnotation:     lastTrim,
+			}),
+			want: true,
+		},
+		"schedule not due": {
+			vol: newVol(map[string]string{
+				utils.TrimScheduleAnnotation: "24h",
+				utils.LastTrimAnnotation:     lastTrim,
+			}),
+		},
+		"no last trim counts from creation": {
+			vol:  newVol(map[string]string{utils.TrimScheduleAnnotation: "24h"}),
+			want: true,
+		},
+		"no last trim not due since creation": {
+			vol: newVol(map[string]string{utils.TrimScheduleAnnotation: "72h"}),
+		},
+		"invalid last trim counts from creation": {
+			vol: newVol(map[string]string{
+				utils.TrimScheduleAnnotation: "24h",
+				utils.LastTrimAnnotation:     "yesterday",
+			}),
+			want: true,
+		},
+		"schedule below scheduler interval": {
+			vol: newVol(map[string]string{
+				utils.TrimScheduleAnnotation: "30s",
+				utils.LastTrimAnnotation:     now.Add(-45 * time.Second).Format(time.RFC3339),
+			}),
+		},
+		"invalid schedule": {
+			vol: newVol(map[string]string{utils.TrimScheduleAnnotation: "daily"}),
+		},
+		"trim requested without schedule": {
+			vol:  newVol(map[string]string{utils.TrimRequestedAnnotation: "true"}),
+			want: true,
+		},
+		"trim requested before schedule": {
+			vol: newVol(map[string]string{
+				utils.TrimScheduleAnnotation:  "24h",
+				utils.LastTrimAnnotation:      lastTrim,
+				utils.TrimRequestedAnnotation: "true",
+			}),
+			want: true,
+		},
+		"encrypted without schedule": {
+			vol: newVol(map[string]string{
+				utils.LUKSDeviceAnnotation:    "pvc-1",
+				utils.TrimRequestedAnnotation: "true",
+			}),
+		},
+		"encrypted with schedule": {
+			vol: newVol(map[string]string{
+				utils.LUKSDeviceAnnotation:   "pvc-1",
+				utils.TrimScheduleAnnotation: "1h",
+				utils.LastTrimAnnotation:     lastTrim,
+			}),
+			want: true,
+		},
+		"not staged": {
+			vol: func() *apis.CStorVolumeAttachment {
+				vol := newVol(map[string]string{utils.TrimRequestedAnnotation: "true"})
+				vol.Finalizers = nil
+				return vol
+			}(),
+		},
+		"raw block": {
+			vol: func() *apis.CStorVolumeAttachment {
+				vol := newVol(map[string]string{utils.TrimRequestedAnnotation: "true"})
+				vol.Spec.Volume.AccessType = "block"
+				return vol
+			}(),
+		},
+		"read-only attachment": {
+			vol: func() *apis.CStorVolumeAttachment {
+				vol := newVol(map[string]string{utils.TrimRequestedAnnotation: "true"})
+				vol.Spec.Volume.AccessModes = []string{utils.MultiNodeReaderOnly}
+				return vol
+			}(),
+		},
+		"being deleted": {
+			vol: func() *apis.CStorVolumeAttachment {
+				vol := newVol(map[string]string{utils.TrimRequestedAnnotation: "true"})
+				deleted := metav1.NewTime(now)
+				vol.DeletionTimestamp = &deleted
+				return vol
+			}(),
+		},
+	}
+	for name, test := range tests {
+		if got := isTrimDue(test.vol, now); got != test.want {
+			t.Errorf("%s: isTrimDue() = %t, want %t", name, got, test.want)
+		}
+	}
+}
@@ -17,15 +17,16 @@
 package driver
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/kubernetes-csi/csi-lib-iscsi/iscsi"
 	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
 	"github.com/openebs/cstor-csi/pkg/cstor/volumeattachment"
 	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
@@ -38,28 +39,34 @@ import (
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 )
 
-func getTargetIP(url string) string {
-	s := strings.Split(url, ":")
-	//ip, port := s[0], s[1]
-	return s[0]
+// getISCSIByPath returns the udev by-path link of the LUN which gets created
+// on the node once it logs in to the given target portal
+func getISCSIByPath(targetPortal, iqn string) string {
+	return strings.Join([]string{
+		"/dev/disk/by-path/ip", targetPortal,
+		"iscsi", iqn, "lun", fmt.Sprint(defaultISCSILUN)}, "-",
+	)
 }
 
+// attachDisk logs in to the target of the volume with the iSCSI session
+// tunables recorded on the CVA and returns the path of the device
 func (ns *node) attachDisk(vol *apis.CStorVolumeAttachment) (string, error) {
-	connector := iscsi.Connector{
-		VolumeName: vol.Spec.Volume.Name,
-		Targets: []iscsi.TargetInfo{
-			iscsi.TargetInfo{
-				Iqn:    vol.Spec.ISCSI.Iqn,
-				Portal: getTargetIP(vol.Spec.ISCSI.TargetPortal),
-			},
-		},
-		Lun:         defaultISCSILUN,
-		Interface:   defaultISCSIInterface,
-		DoDiscovery: true,
+	sessionParams, err := getCVASessionParams(vol)
+	if err != nil {
+		return "", err
+	}
+	if netIface := vol.Annotations[utils.ISCSIHostInterfaceAnnotation]; netIface != "" {
+		iface, err := iscsiutils.BindIface(netIface)
+		if err != nil {
+			return "", status.Errorf(codes.FailedPrecondition,
+				"failed to bind iSCSI logins of volume %s: %v", vol.Spec.Volume.Name, err)
+		}
+		vol.Spec.ISCSI.IscsiInterface = iface
 	}
 
-	logrus.Debugf("NodeStageVolume: attach disk with config: {%+v}", connector)
-	devicePath, err := iscsi.Connect(connector)
+	logrus.Debugf("NodeStageVolume: attach disk %s at %s with session params: {%+v}",
+		vol.Spec.ISCSI.Iqn, vol.Spec.ISCSI.TargetPortal, sessionParams)
+	devicePath, err := iscsiutils.AttachDisk(vol, sessionParams)
 	if err != nil {
 		return "", err
 	}
@@ -67,39 +74,238 @@ func (ns *node) attachDisk(vol *apis.CStorVolumeAttachment) (string, error) {
 	if devicePath == "" {
 		return "", fmt.Errorf("connect reported success, but no path returned")
 	}
+	// The LUN is shared with other nodes, none of them may write to it
+	if utils.IsReadOnlyAttachment(vol) {
+		if err := iscsiutils.SetDeviceReadOnly(devicePath); err != nil {
+			return "", err
+		}
+	}
 	return devicePath, err
 }
 
-func (ns *node) formatAndMount(req *csi.NodeStageVolumeRequest, devicePath string) error {
+// isMultiNodeReadOnly returns true if the volume is requested to be
+// attached read-only to several nodes
+func isMultiNodeReadOnly(volCap *csi.VolumeCapability) bool {
+	return volCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// stagingMountFlags returns the options the volume is mounted with at the
+// staging path. Volumes shared read-only are mounted without replaying the
+// filesystem journal, which would write to the device. Volumes mounted for
+// a group are mounted so that new files take the group of their directory
+// where the filesystem supports it.
+func stagingMountFlags(volCap *csi.VolumeCapability, fsType string) []string {
+	flags := append([]string{}, volCap.GetMount().GetMountFlags()...)
+	readOnly := isMultiNodeReadOnly(volCap)
+	if !readOnly && volCap.GetMount().GetVolumeMountGroup() == "" {
+		return flags
+	}
+	if fsType == "" {
+		fsType = defaultFsType
+	}
+	fs, ok := iscsiutils.LookupFilesystem(fsType)
+	var extraFlags []string
+	switch {
+	case readOnly:
+		extraFlags = append(extraFlags, "ro")
+		if ok {
+			extraFlags = append(extraFlags, fs.ReadOnlyMountOptions...)
+		}
+	case ok:
+		extraFlags = append(extraFlags, fs.GroupMountOptions...)
+	}
+	for _, flag := range extraFlags {
+		if !containsFlag(flags, flag) {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// ensureVolumeMountGroup applies the mount group to the filesystem staged at
+// the given path unless it has been already, and records it on the CVA,
+// which the caller updates. It returns true if the CVA has been changed.
+// While the volume is staged it can't be used by another group, the pods
+// of one of the groups would not have access to the files of the other.
+func ensureVolumeMountGroup(vol *apis.CStorVolumeAttachment, stagingTargetPath, group string) (bool, error) {
+	if _, err := strconv.ParseUint(group, 10, 32); err != nil {
+		return false, status.Errorf(codes.InvalidArgument,
+			"invalid volume mount group %q: %v", group, err)
+	}
+	switch applied := vol.Annotations[utils.VolumeMountGroupAnnotation]; applied {
+	case group:
+		return false, nil
+	case "":
+	default:
+		return false, status.Errorf(codes.FailedPrecondition,
+			"volume %s is used by group %s on node %s, it can't be used by group %s",
+			vol.Spec.Volume.Name, applied, utils.NodeIDENV, group)
+	}
+	if err := applyVolumeMountGroup(stagingTargetPath, group); err != nil {
+		return false, status.Errorf(codes.Internal,
+			"failed to apply group %s to volume %s: %v", group, vol.Spec.Volume.Name, err)
+	}
+	if vol.Annotations == nil {
+		vol.Annotations = map[string]string{}
+	}
+	vol.Annotations[utils.VolumeMountGroupAnnotation] = group
+	return true, nil
+}
+
+// applyVolumeMountGroup gives the group the ownership of the root of the
+// filesystem mounted at the given path, along with read, write and setgid
+// permissions, so that the files created in it belong to the group. Unlike
+// the fsGroup handling of kubelet, the existing files are left as they are.
+func applyVolumeMountGroup(mntPath, group string) error {
+	gid, err := strconv.Atoi(group)
+	if err != nil {
+		return err
+	}
+	if err = os.Lchown(mntPath, -1, gid); err != nil {
+		return err
+	}
+	info, err := os.Stat(mntPath)
+	if err != nil {
+		return err
+	}
+	mode := info.Mode() | 0070 | os.ModeSetgid
+	if mode == info.Mode() {
+		return nil
+	}
+	return os.Chmod(mntPath, mode)
+}
+
+func containsFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveFsType probes the device and returns the filesystem type it is to
+// be mounted with and whether the device is blank. A device holding anything but the requested filesystem
+// is never formatted, its filesystem is adopted if the storage class allows
+// it and the stage fails with FailedPrecondition otherwise.
+func resolveFsType(devicePath, fsType string, volumeContext map[string]string) (string, bool, error) {
+	if fsType == "" {
+		fsType = defaultFsType
+	}
+	sig, err := iscsiutils.ProbeDevice(devicePath)
+	if err != nil {
+		return "", false, err
+	}
+	if sig.Blank() || (sig.Type == fsType && sig.PTType == "") {
+		return fsType, sig.Blank(), nil
+	}
+	adopt, _ := strconv.ParseBool(volumeContext[adoptExistingFilesystemKey])
+	_, supported := iscsiutils.LookupFilesystem(sig.Type)
+	supported = supported && sig.PTType == ""
+	if adopt && supported {
+		logrus.Warningf("Device %s holds %s instead of the requested %s, adopting it",
+			devicePath, sig, fsType)
+		return sig.Type, false, nil
+	}
+	msg := fmt.Sprintf("device %s holds %s, not the requested %s filesystem", devicePath, sig, fsType)
+	if supported {
+		msg += fmt.Sprintf(", set storage class parameter %s to mount it as is", adoptExistingFilesystemKey)
+	}
+	return "", false, status.Error(codes.FailedPrecondition, msg)
+}
+
+// formatAndMount mounts the device at the staging path, formatting it if it
+// is blank, and returns the filesystem type it is mounted with. An existing
+// filesystem is first checked as set by the fsck policy of the volume and
+// the outcome is recorded on the given CVA.
+func (ns *node) formatAndMount(
+	req *csi.NodeStageVolumeRequest,
+	vol *apis.CStorVolumeAttachment,
+	devicePath string,
+) (string, error) {
 	// Mount device
 	mntPath := req.GetStagingTargetPath()
 	notMnt, err := ns.mounter.IsLikelyNotMountPoint(mntPath)
 	if err != nil && !os.IsNotExist(err) {
 		if err := os.MkdirAll(mntPath, 0750); err != nil {
 			logrus.Errorf("failed to mkdir %s, error", mntPath)
-			return err
+			return "", err
 		}
 	}
 
 	if !notMnt {
 		logrus.Infof("Volume %s has been mounted already at %v", req.GetVolumeId(), mntPath)
-		return nil
+		return req.GetVolumeCapability().GetMount().GetFsType(), nil
 	}
 
-	fsType := req.GetVolumeCapability().GetMount().GetFsType()
-	options := []string{}
-	mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
-	options = append(options, mountFlags...)
-
-	err = ns.mounter.FormatAndMount(devicePath, mntPath, fsType, options)
+	fsType, err := ns.mountStagingPath(vol, devicePath, mntPath,
+		req.GetVolumeCapability().GetMount().GetFsType(), req.GetVolumeContext(),
+		func(fsType string) []string {
+			return stagingMountFlags(req.GetVolumeCapability(), fsType)
+		})
 	if err != nil {
 		logrus.Errorf(
 			"Failed to mount iscsi volume %s [%s, %s] to %s, error %v",
 			req.GetVolumeId(), devicePath, fsType, mntPath, err,
 		)
-		return err
+		return "", err
 	}
-	return nil
+	return fsType, nil
+}
+
+// mountStagingPath mounts the device at the staging path with the options
+// returned by mountFlags for the filesystem type it resolves to. A blank
+// device is formatted with the mkfs options of the volume context, an
+// existing filesystem is first checked as set by its fsck policy and the
+// outcome is recorded on the given CVA.
+func (ns *node) mountStagingPath(
+	vol *apis.CStorVolumeAttachment,
+	devicePath, mntPath, fsType string,
+	volumeContext map[string]string,
+	mountFlags func(fsType string) []string,
+) (string, error) {
+	fsType, blank, err := resolveFsType(devicePath, fsType, volumeContext)
+	if err != nil {
+		return "", err
+	}
+	options := mountFlags(fsType)
+
+	// Without a policy the filesystem is checked by FormatAndMount the way
+	// it always was, read-only mounts are never checked
+	policy := iscsiutils.FsckPolicy(volumeContext[fsckPolicyKey])
+	if policy != "" && !blank && !containsFlag(options, "ro") {
+		if err := checkFilesystem(vol, devicePath, fsType, policy); err != nil {
+			return "", err
+		}
+		return fsType, ns.mounter.Mount(devicePath, mntPath, fsType, options)
+	}
+	mkfsOptions := strings.Fields(volumeContext[mkfsOptionsKey])
+	return fsType, ns.mounter.FormatAndMountWithMkfsOptions(devicePath, mntPath, fsType, mkfsOptions, options)
+}
+
+// restoreStagingMount mounts the staging path of a staged volume again with
+// the filesystem type, options and fsck policy recorded on its CVA, the
+// outcome of the check is recorded on the CVA as it is on stage. The
+// filesystem on the device is mounted as is when no type was recorded.
+func (ns *node) restoreStagingMount(vol *apis.CStorVolumeAttachment) error {
+	fsType, options := utils.StagingMountOptions(vol)
+	volumeContext := map[string]string{
+		mkfsOptionsKey: vol.Annotations[utils.MkfsOptionsAnnotation],
+		fsckPolicyKey:  vol.Annotations[utils.FsckPolicyAnnotation],
+	}
+	if fsType == "" {
+		volumeContext[adoptExistingFilesystemKey] = "true"
+	}
+	fsckResult := vol.Annotations[utils.FsckResultAnnotation]
+	_, err := ns.mountStagingPath(vol, utils.MountDevicePath(vol), vol.Spec.Volume.StagingTargetPath,
+		fsType, volumeContext, func(string) []string { return options })
+	if result := vol.Annotations[utils.FsckResultAnnotation]; result != fsckResult {
+		if uerr := utils.UpdateCStorVolumeAttachmentAnnotations(vol.Name,
+			map[string]string{utils.FsckResultAnnotation: result}); uerr != nil {
+			logrus.Errorf("Failed to record fsck result of volume %s: %v", vol.Spec.Volume.Name, uerr)
+		}
+	}
+	return err
 }
 
 func (ns *node) nodePublishVolumeForFileSystem(req *csi.NodePublishVolumeRequest, mountOptions []string, mode *csi.VolumeCapability_Mount) error {
@@ -200,7 +406,7 @@ func (ns *node) GetDevicePath(devicePath, volumeID string) (string, error) {
 	if err != nil {
 		return "", status.Error(codes.Internal, err.Error())
 	}
-	return vol.Spec.Volume.DevicePath, nil
+	return utils.MountDevicePath(vol), nil
 }
 
 // newNodeCapabilities returns a list
@@ -223,6 +429,9 @@ func newNodeCapabilities() []*csi.NodeServiceCapability {
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+		csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
 	} {
 		capabilities = append(capabilities, fromType(cap))
 	}
@@ -300,6 +509,13 @@ func (ns *node) validateNodeStageReq(
 		return status.Error(codes.InvalidArgument,
 			"Volume ID missing in request")
 	}
+
+	if group := req.GetVolumeCapability().GetMount().GetVolumeMountGroup(); group != "" {
+		if _, err := strconv.ParseUint(group, 10, 32); err != nil {
+			return status.Errorf(codes.InvalidArgument,
+				"invalid volume mount group %q: %v", group, err)
+		}
+	}
 	return nil
 }
 
@@ -340,6 +556,7 @@ func (ns *node) prepareVolumeForNode(
 	//				2. Multiple pod instances of same (or) different deployments can run on same
 	//				   node(If we add checks then rolling update strategy will never work).
 
+	readOnly := isMultiNodeReadOnly(req.GetVolumeCapability())
 	existingCSIVols, err := utils.GetVolList(volumeID)
 	if err != nil {
 		return err
@@ -356,6 +573,10 @@ func (ns *node) prepareVolumeForNode(
 			// operation failed during in next reconciliation things should work smooth
 			continue
 		}
+		// Read-only attachments can coexist on several nodes
+		if readOnly && utils.IsReadOnlyAttachment(&csiVol) {
+			continue
+		}
 		oldNodeName := csiVol.GetLabels()["nodeID"]
 
 		if oldNodeName == nodeID {
@@ -385,31 +606,81 @@ func (ns *node) prepareVolumeForNode(
 		accessType = "mount"
 	}
 
+	sessionParams, err := getISCSISessionParams(req.GetVolumeContext())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	annotations := map[string]string{}
+	if len(sessionParams) != 0 {
+		params, err := json.Marshal(sessionParams)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		annotations[utils.ISCSISessionParamsAnnotation] = string(params)
+	}
+
+	// The storage network of the volume takes precedence over the one the
+	// node plugin is configured with
+	hostIface := req.GetVolumeContext()[iscsiHostInterfaceKey]
+	if hostIface == "" {
+		hostIface = ns.driver.config.ISCSIHostInterface
+	}
+	if hostIface != "" {
+		netIface, err := iscsiutils.ResolveHostInterface(hostIface)
+		if err != nil {
+			return status.Errorf(codes.FailedPrecondition,
+				"failed to bind iSCSI logins of volume %s: %v", volumeID, err)
+		}
+		annotations[utils.ISCSIHostInterfaceAnnotation] = netIface
+	}
+
 	vol, err := volumeattachment.NewBuilder().
 		WithName(volumeID + "-" + nodeID).
 		WithLabels(labels).
+		WithAnnotations(annotations).
 		WithVolName(req.GetVolumeId()).
 		WithAccessType(accessType).
+		WithAccessModes([]string{req.GetVolumeCapability().GetAccessMode().GetMode().String()}).
 		WithFSType(req.GetVolumeCapability().GetMount().GetFsType()).
 		WithReadOnly(false).Build()
 	if err != nil {
 		return err
 	}
-	if isCVCBound, err := utils.IsCVCBound(volumeID); err != nil {
+	// A statically provisioned PV names an existing CStorVolume which has
+	// no CVC, e.g. after the cluster has been rebuilt
+	isCVCBound, err := utils.IsCVCBound(volumeID)
+	isStatic := k8serror.IsNotFound(err) && req.GetVolumeContext()[ephemeralKey] != "true"
+	if err != nil && !isStatic {
 		return status.Error(codes.Internal, err.Error())
-	} else if !isCVCBound {
-		utils.TransitionVolListLock.Lock()
-		utils.TransitionVolList[volumeID] = apis.CStorVolumeAttachmentStatusWaitingForCVCBound
-		utils.TransitionVolListLock.Unlock()
+	} else if err == nil && !isCVCBound {
+		ns.ops.update(volumeID, apis.CStorVolumeAttachmentStatusWaitingForCVCBound)
 		time.Sleep(10 * time.Second)
 		return errors.Errorf("Waiting for %s's CVC to be bound", volumeID)
 	}
 
 	if err = utils.FetchAndUpdateISCSIDetails(volumeID, vol); err != nil {
+		if k8serror.IsNotFound(err) {
+			return status.Errorf(codes.FailedPrecondition,
+				"volume %s has neither a CVC nor a CStorVolume", volumeID)
+		}
 		return err
 	}
+	// The iSCSI details set in the volume attributes of a static PV take
+	// precedence over the ones of the CStorVolume. Only the cluster admin
+	// creates those, the attributes of other volumes are never trusted
+	// with the target the node logs in to.
+	if isStatic {
+		if err = iscsiutils.ApplyVolumeContext(vol, req.GetVolumeContext()); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	// Logins and logouts of the volume go through the iface bound to the
+	// storage network, it is recorded before the CVA gets created
+	if netIface := annotations[utils.ISCSIHostInterfaceAnnotation]; netIface != "" {
+		vol.Spec.ISCSI.IscsiInterface = iscsiutils.BoundIfaceName(netIface)
+	}
 
-	if err = utils.DeleteOldCStorVolumeAttachmentCRs(volumeID, nodeID); err != nil {
+	if err = utils.DeleteOldCStorVolumeAttachmentCRs(volumeID, nodeID, readOnly); err != nil {
 		return status.Error(codes.Internal, err.Error())
 	}
 	if err = utils.CreateCStorVolumeAttachmentCR(vol, nodeID); err != nil {
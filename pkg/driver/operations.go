@@ -0,0 +1,144 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// volumeOperation is the operation running on a volume
+type volumeOperation struct {
+	// state of the volume as the operation progresses
+	state apis.CStorVolumeAttachmentStatus
+	// since is the time at which the operation started
+	since time.Time
+}
+
+// backgroundTask is a task running on a volume which gives way to the
+// operations on the volume
+type backgroundTask struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// operationManager allows only one operation at a time on a volume. An
+// operation owns the volume from start till done, any other operation
+// on the same volume is rejected in the meanwhile. A background task, such
+// as a trim, never holds off an operation: it is cancelled as soon as an
+// operation starts on its volume. The lock is held only to update the
+// in-flight operations, never while the operation runs.
+type operationManager struct {
+	lock       sync.Mutex
+	operations map[string]*volumeOperation
+	background map[string]*backgroundTask
+}
+
+func newOperationManager() *operationManager {
+	return &operationManager{
+		operations: map[string]*volumeOperation{},
+		background: map[string]*backgroundTask{},
+	}
+}
+
+// start marks the beginning of an operation on the volume, it returns an
+// Aborted error if another operation is already running on the volume. A
+// background task running on the volume is cancelled and waited for.
+func (om *operationManager) start(volumeID string, state apis.CStorVolumeAttachmentStatus) error {
+	om.lock.Lock()
+	if op, ok := om.operations[volumeID]; ok {
+		om.lock.Unlock()
+		return status.Errorf(codes.Aborted,
+			"an operation is already in progress on volume %s: %s since %s",
+			volumeID, op.state, op.since.Format(time.RFC3339))
+	}
+	om.operations[volumeID] = &volumeOperation{state: state, since: time.Now()}
+	task := om.background[volumeID]
+	om.lock.Unlock()
+
+	logrus.Debugf("Volume %s: started operation in %s state", volumeID, state)
+	if task != nil {
+		logrus.Infof("Volume %s: cancelling background task for %s operation", volumeID, state)
+		task.cancel()
+		<-task.done
+	}
+	return nil
+}
+
+// startBackground marks the beginning of a background task on the volume,
+// the returned context is cancelled as soon as an operation starts on the
+// volume. An Aborted error is returned if an operation or another
+// background task is running on the volume.
+func (om *operationManager) startBackground(volumeID string) (context.Context, error) {
+	om.lock.Lock()
+	defer om.lock.Unlock()
+
+	if op, ok := om.operations[volumeID]; ok {
+		return nil, status.Errorf(codes.Aborted,
+			"an operation is already in progress on volume %s: %s since %s",
+			volumeID, op.state, op.since.Format(time.RFC3339))
+	}
+	if _, ok := om.background[volumeID]; ok {
+		return nil, status.Errorf(codes.Aborted,
+			"a background task is already running on volume %s", volumeID)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	om.background[volumeID] = &backgroundTask{cancel: cancel, done: make(chan struct{})}
+	return ctx, nil
+}
+
+// doneBackground marks the end of the background task on the volume
+func (om *operationManager) doneBackground(volumeID string) {
+	om.lock.Lock()
+	task, ok := om.background[volumeID]
+	delete(om.background, volumeID)
+	om.lock.Unlock()
+
+	if ok {
+		task.cancel()
+		close(task.done)
+	}
+}
+
+// update records the progress of the operation running on the volume
+func (om *operationManager) update(volumeID string, state apis.CStorVolumeAttachmentStatus) {
+	om.lock.Lock()
+	defer om.lock.Unlock()
+
+	if op, ok := om.operations[volumeID]; ok {
+		op.state = state
+		logrus.Infof("Volume %s is in %s state", volumeID, state)
+	}
+}
+
+// done marks the end of the operation running on the volume
+func (om *operationManager) done(volumeID string) {
+	om.lock.Lock()
+	defer om.lock.Unlock()
+
+	if op, ok := om.operations[volumeID]; ok {
+		logrus.Infof("Volume %s: operation completed in %s state after %v",
+			volumeID, op.state, time.Since(op.since).Round(time.Millisecond))
+		delete(om.operations, volumeID)
+	}
+}
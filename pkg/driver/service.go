@@ -26,6 +26,7 @@ import (
 	"github.com/openebs/cstor-csi/pkg/version"
 	analytics "github.com/openebs/google-analytics-4/usage"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const (
@@ -64,6 +65,9 @@ type CSIDriver struct {
 func GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_AccessMode {
 	supported := []csi.VolumeCapability_AccessMode_Mode{
 		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
 	}
 
 	var vcams []*csi.VolumeCapability_AccessMode
@@ -90,9 +94,12 @@ func New(config *config.Config) *CSIDriver {
 		driver.cs = NewController(driver)
 
 	case "node":
-		if err := utils.Cleanup(); err != nil {
-			logrus.Fatalf(err.Error())
-		}
+		ns := newNode(driver)
+		// Reconcile the volumes of this node with their CVAs in
+		// the background, finishing or rolling back the operations
+		// interrupted by the restart and logging out of the iSCSI
+		// sessions left behind by volumes which no longer have a CVA
+		go ns.reconcileNode()
 		// Start monitor goroutine to monitor the
 		// mounted paths. If a path goes down or
 		// becomes read only (in case of RW mount
@@ -100,10 +107,33 @@ func New(config *config.Config) *CSIDriver {
 		// and relogin or remount
 		if os.Getenv("REMOUNT") == "true" {
 			logrus.Infof("Monitoring mounts")
-			go utils.MonitorMounts()
+			go ns.monitorMounts(wait.NeverStop)
+		}
+
+		// Start monitor goroutine to verify the iSCSI
+		// sessions of the staged volumes. If a session
+		// fails, logs out or points to a stale portal,
+		// this thread will relogin or rescan it
+		if config.ISCSISessionMonitorInterval > 0 {
+			logrus.Infof("Monitoring iSCSI sessions every %v", config.ISCSISessionMonitorInterval)
+			go ns.monitorISCSISessions(config.ISCSISessionMonitorInterval)
+		}
+
+		// Start watching the target portals of the
+		// volumes. If the target service or pod IP
+		// changes, the sessions of the volumes staged
+		// on this node are moved to the new portal
+		go ns.watchTargetPortals(wait.NeverStop)
+
+		// Start the trim scheduler which discards the
+		// blocks freed by the filesystems of the volumes
+		// so that the cStor pools can reclaim them
+		if config.MaxConcurrentTrims > 0 {
+			logrus.Infof("Trimming up to %d volumes at once", config.MaxConcurrentTrims)
+			go ns.scheduleTrims(config.MaxConcurrentTrims)
 		}
 
-		driver.ns = NewNode(driver)
+		driver.ns = ns
 	}
 
 	// Identity server is common to both node and
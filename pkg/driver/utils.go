@@ -17,11 +17,12 @@
 package driver
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/sirupsen/logrus"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	apisv1 "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
 	utils "github.com/openebs/cstor-csi/pkg/utils"
 	"golang.org/x/sys/unix"
 	corev1 "k8s.io/api/core/v1"
@@ -36,6 +37,8 @@ const (
 	FSTypeExt4 = "ext4"
 	// FSTypeXfs represents te xfs filesystem type
 	FSTypeXfs = "xfs"
+	// FSTypeBtrfs represents the btrfs filesystem type
+	FSTypeBtrfs = "btrfs"
 
 	defaultFsType = FSTypeExt4
 
@@ -59,11 +62,71 @@ const (
 	// pvNameKey holds the name of the PV which is passed as a parameter
 	// in CreateVolume request
 	pvNameKey = "csi.storage.k8s.io/pv/name"
+
+	// iscsiReplacementTimeoutKey is the storage class parameter which tunes
+	// the time the node waits for a failed iSCSI session to come back
+	iscsiReplacementTimeoutKey = "iscsiReplacementTimeout"
+
+	// iscsiCmdsMaxKey is the storage class parameter which tunes the
+	// number of commands that can be queued on the iSCSI session
+	iscsiCmdsMaxKey = "iscsiCmdsMax"
+
+	// iscsiQueueDepthKey is the storage class parameter which tunes the
+	// number of commands that can be queued on the LUN
+	iscsiQueueDepthKey = "iscsiQueueDepth"
+
+	// iscsiNoopOutIntervalKey is the storage class parameter which tunes
+	// the interval of the nop-out pings sent to the target
+	iscsiNoopOutIntervalKey = "iscsiNoopOutInterval"
+
+	// iscsiHostInterfaceKey is the storage class parameter which names the
+	// host network interface, or a CIDR, the iSCSI logins are bound to. It
+	// overrides the --iscsi-host-interface flag of the node plugin.
+	iscsiHostInterfaceKey = "iscsiHostInterface"
+
+	// encryptedKey is the storage class parameter which turns on LUKS
+	// encryption of the volume on the node
+	encryptedKey = "encrypted"
+
+	// encryptionPassphraseKey is the key of the LUKS passphrase in the node
+	// stage and node expand secrets of encrypted volumes
+	encryptionPassphraseKey = "encryptionPassphrase"
+
+	// mkfsOptionsKey is the storage class parameter which holds the extra
+	// options the filesystem of the volume is created with, separated by
+	// whitespace
+	mkfsOptionsKey = "mkfsOptions"
+
+	// adoptExistingFilesystemKey is the storage class parameter which lets
+	// a volume be mounted with the filesystem already on its device when it
+	// differs from the requested one, instead of failing the stage
+	adoptExistingFilesystemKey = "adoptExistingFilesystem"
+
+	// fsckPolicyKey is the storage class parameter which tells how the
+	// filesystem of the volume is checked before it is staged, one of
+	// never, check, repair-safe or repair-force
+	fsckPolicyKey = "fsckPolicy"
+
+	// trimScheduleKey is the storage class parameter which holds the
+	// interval at which the filesystem of the volume is trimmed, e.g. 24h.
+	// The LUKS device of an encrypted volume passes discards on only if it
+	// is set.
+	trimScheduleKey = "trimSchedule"
 )
 
 var (
-	// ValidFSTypes supported filesystems for provisioning and resize operations
-	ValidFSTypes = []string{FSTypeExt4, FSTypeXfs}
+	// ValidFSTypes supported filesystems for provisioning and resize
+	// operations, these are the ones the node plugin has a handler for
+	ValidFSTypes = iscsiutils.FilesystemTypes()
+
+	// iscsiSessionParamKeys maps the storage class parameters to the
+	// iscsiadm node settings they tune
+	iscsiSessionParamKeys = map[string]string{
+		iscsiReplacementTimeoutKey: iscsiutils.ReplacementTimeout,
+		iscsiCmdsMaxKey:            iscsiutils.CmdsMax,
+		iscsiQueueDepthKey:         iscsiutils.QueueDepth,
+		iscsiNoopOutIntervalKey:    iscsiutils.NoopOutInterval,
+	}
 )
 
 func isValidFStype(fstype string) bool {
@@ -75,6 +138,37 @@ func isValidFStype(fstype string) bool {
 	return false
 }
 
+// getISCSISessionParams returns the iscsiadm node settings requested
+// through the given storage class parameters or volume context
+func getISCSISessionParams(params map[string]string) (map[string]string, error) {
+	sessionParams := map[string]string{}
+	for param, key := range iscsiSessionParamKeys {
+		value, ok := params[param]
+		if !ok {
+			continue
+		}
+		if err := iscsiutils.ValidateSessionParam(key, value); err != nil {
+			return nil, fmt.Errorf("invalid storage class parameter %s: %v", param, err)
+		}
+		sessionParams[key] = value
+	}
+	return sessionParams, nil
+}
+
+// getCVASessionParams returns the iscsiadm node settings recorded on the
+// CVA during NodeStageVolume
+func getCVASessionParams(vol *apisv1.CStorVolumeAttachment) (map[string]string, error) {
+	sessionParams := map[string]string{}
+	value, ok := vol.Annotations[utils.ISCSISessionParamsAnnotation]
+	if !ok {
+		return sessionParams, nil
+	}
+	if err := json.Unmarshal([]byte(value), &sessionParams); err != nil {
+		return nil, fmt.Errorf("failed to decode iSCSI session params of cva %s: %v", vol.Name, err)
+	}
+	return sessionParams, nil
+}
+
 // IsBlockDevice checks if the given path is a block device
 func IsBlockDevice(fullPath string) (bool, error) {
 	var st unix.Stat_t
@@ -85,25 +179,6 @@ func IsBlockDevice(fullPath string) (bool, error) {
 	return (st.Mode & unix.S_IFMT) == unix.S_IFBLK, nil
 }
 
-func removeVolumeFromTransitionList(volumeID string) {
-	utils.TransitionVolListLock.Lock()
-	defer utils.TransitionVolListLock.Unlock()
-	delete(utils.TransitionVolList, volumeID)
-	logrus.Infof("Volume with ID: %v has been successfully removed from the transition volume list: %v", volumeID, utils.TransitionVolList)
-}
-
-func addVolumeToTransitionList(volumeID string, status apisv1.CStorVolumeAttachmentStatus) error {
-	utils.TransitionVolListLock.Lock()
-	defer utils.TransitionVolListLock.Unlock()
-
-	if _, ok := utils.TransitionVolList[volumeID]; ok {
-		return fmt.Errorf("Volume %s Busy, status: %v",
-			volumeID, utils.TransitionVolList[volumeID])
-	}
-	utils.TransitionVolList[volumeID] = status
-	return nil
-}
-
 // getCapacity converts capacity as string
 func getCapacity(cvc *apisv1.CStorVolumeConfig) string {
 	qCap := cvc.Spec.Capacity[corev1.ResourceStorage]
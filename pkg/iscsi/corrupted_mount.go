@@ -0,0 +1,63 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"k8s.io/utils/mount"
+)
+
+// IsCorruptedMount returns true if the error was returned by an operation on
+// a mount point whose device has gone away, e.g. the LUN was lost along with
+// the iSCSI session. Such mount points fail stat and statfs with ENOTCONN,
+// ESTALE or EIO but can still be unmounted.
+func IsCorruptedMount(err error) bool {
+	if err == nil {
+		return false
+	}
+	if mount.IsCorruptedMnt(err) {
+		return true
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno == syscall.ENOTCONN || errno == syscall.ESTALE || errno == syscall.EIO
+	}
+	return false
+}
+
+// ForceUnmount unmounts the corrupted mount point at the given path. It
+// tries a forced unmount first and falls back to a lazy unmount, which
+// detaches the mount point right away even if IOs are stuck on the lost
+// device.
+func ForceUnmount(path string) error {
+	logrus.Warningf("iscsi: force unmounting corrupted mount point %s", path)
+	err := unix.Unmount(path, unix.MNT_FORCE)
+	if err == nil || err == unix.EINVAL {
+		// EINVAL is returned once the path is no longer a mount point
+		return nil
+	}
+	logrus.Warningf("iscsi: forced unmount of %s failed: %v, trying lazy unmount", path, err)
+	if err = unix.Unmount(path, unix.MNT_DETACH); err != nil && err != unix.EINVAL {
+		return fmt.Errorf("failed to lazy unmount %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsCorruptedMount(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"nil":            {err: nil, want: false},
+		"not connected":  {err: &os.PathError{Op: "stat", Path: "/mnt", Err: syscall.ENOTCONN}, want: true},
+		"io error":       {err: &os.PathError{Op: "statfs", Path: "/mnt", Err: syscall.EIO}, want: true},
+		"wrapped errno":  {err: fmt.Errorf("statfs failed: %w", syscall.ESTALE), want: true},
+		"bare errno":     {err: syscall.EIO, want: true},
+		"not exist":      {err: &os.PathError{Op: "stat", Path: "/mnt", Err: syscall.ENOENT}, want: false},
+		"unrelated":      {err: errors.New("timed out"), want: false},
+		"wrapped enoent": {err: fmt.Errorf("stat: %w", syscall.ENOENT), want: false},
+	}
+	for name, test := range tests {
+		if got := IsCorruptedMount(test.err); got != test.want {
+			t.Errorf("%s: IsCorruptedMount(%v) = %v, want %v", name, test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	utilexec "k8s.io/utils/exec"
+	"k8s.io/utils/mount"
+)
+
+const (
+	sysBlockPath  = "/sys/block"
+	mountInfoPath = "/proc/self/mountinfo"
+
+	// resizeWaitTimeout is how long a device is waited for to reach its
+	// new size after the session is rescanned
+	resizeWaitTimeout = 30 * time.Second
+)
+
+// InUseDevices returns the disks of the session which are either mounted,
+// bind mounted as raw block devices or held by another device such as a
+// multipath or dm-crypt device
+func (s *Session) InUseDevices() ([]string, error) {
+	infos, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", mountInfoPath, err)
+	}
+	var inUse []string
+	for _, dev := range s.Devices {
+		holders, err := os.ReadDir(filepath.Join(sysBlockPath, dev.Name, "holders"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(holders) != 0 {
+			inUse = append(inUse, dev.Name)
+			continue
+		}
+		var major, minor int
+		data, err := os.ReadFile(filepath.Join(sysBlockPath, dev.Name, "dev"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if _, err := fmt.Sscanf(strings.TrimSpace(string(data)), "%d:%d", &major, &minor); err != nil {
+			return nil, fmt.Errorf("failed to parse device number of %s: %v", dev.Name, err)
+		}
+		if isDeviceMounted(dev.Name, major, minor, infos) {
+			inUse = append(inUse, dev.Name)
+		}
+	}
+	return inUse, nil
+}
+
+// isDeviceMounted returns true if a filesystem on the given device is
+// mounted or if the device node itself is bind mounted, which is how raw
+// block volumes are published
+func isDeviceMounted(name string, major, minor int, infos []mount.MountInfo) bool {
+	for _, info := range infos {
+		if info.Major == major && info.Minor == minor {
+			return true
+		}
+		if info.FsType == "devtmpfs" && info.Root == "/"+name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDeviceReadOnly marks the block device read-only in the kernel so that
+// neither the filesystem nor a raw block consumer can write to a LUN which
+// is shared read-only with other nodes
+func SetDeviceReadOnly(devicePath string) error {
+	out, err := utilexec.New().Command("blockdev", "--setro", devicePath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to set %s read-only: %s (%v)", devicePath, string(out), err)
+	}
+	return nil
+}
+
+// DeviceSize returns the size of the block device in bytes as seen by the
+// kernel
+func DeviceSize(devicePath string) (int64, error) {
+	out, err := utilexec.New().Command("blockdev", "--getsize64", devicePath).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get size of %s: %s (%v)", devicePath, string(out), err)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse size of %s: %v", devicePath, err)
+	}
+	return size, nil
+}
+
+// waitForDeviceSize waits till the device is at least the given size or
+// the timeout expires and returns the last size seen
+func waitForDeviceSize(devicePath string, size int64, timeout time.Duration) (int64, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := DeviceSize(devicePath)
+		if err != nil || current >= size || time.Now().After(deadline) {
+			return current, err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// resizeMultipath grows the multipath device to the size of its paths
+func resizeMultipath(devicePath string) error {
+	name, err := os.ReadFile(filepath.Join(sysBlockPath, filepath.Base(devicePath), "dm", "name"))
+	if err != nil {
+		return fmt.Errorf("failed to get map name of %s: %v", devicePath, err)
+	}
+	out, err := utilexec.New().Command("multipathd", "resize", "map", strings.TrimSpace(string(name))).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to resize multipath device %s: %s (%v)", devicePath, string(out), err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"testing"
+
+	"k8s.io/utils/mount"
+)
+
+func TestIsDeviceMounted(t *testing.T) {
+	infos := []mount.MountInfo{
+		{Major: 0, Minor: 5, Root: "/", FsType: "devtmpfs", MountPoint: "/dev"},
+		{Major: 8, Minor: 16, Root: "/", FsType: "ext4", MountPoint: "/var/lib/kubelet/staging"},
+		{Major: 0, Minor: 5, Root: "/sdc", FsType: "devtmpfs", MountPoint: "/var/lib/kubelet/block/pvc-2"},
+	}
+	tests := map[string]struct {
+		name         string
+		major, minor int
+		want         bool
+	}{
+		"filesystem mounted":  {"sdb", 8, 16, true},
+		"block bind mounted":  {"sdc", 8, 32, true},
+		"device not mounted":  {"sdd", 8, 48, false},
+		"other device of dev": {"sde", 8, 64, false},
+	}
+	for name, test := range tests {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			if got := isDeviceMounted(test.name, test.major, test.minor, infos); got != test.want {
+				t.Fatalf("expected mounted %t for %s, got %t", test.want, test.name, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	utilexec "k8s.io/utils/exec"
+)
+
+// Filesystem describes how the node plugin creates, mounts, grows and
+// reports on a filesystem type
+type Filesystem struct {
+	// Magic is the filesystem type reported by statfs
+	Magic int64
+	// MkfsArgs are passed to mkfs.<type> ahead of the device
+	MkfsArgs []string
+	// MkfsFlags are the mkfs flags which can be set through the storage
+	// class, mapped to whether they take a value
+	MkfsFlags map[string]bool
+	// ReadOnlyMountOptions are added when the filesystem is mounted
+	// read-only so that the mount doesn't write to the device, e.g. by
+	// replaying the journal
+	ReadOnlyMountOptions []string
+	// GroupMountOptions are added when the volume is mounted for a group
+	// so that new files and directories take the group of their parent
+	// directory, filesystems without one rely on the setgid bit
+	GroupMountOptions []string
+	// Grow grows the filesystem of the device mounted at the given path
+	// to the size of the device
+	Grow func(devicePath, mountPath string) error
+	// Fsck checks the filesystem on the unmounted device, repairing it as
+	// allowed by the policy
+	Fsck func(devicePath string, policy FsckPolicy) (*FsckResult, error)
+	// DynamicInodes is set for filesystems which allocate inodes on
+	// demand and have no inode limit to report
+	DynamicInodes bool
+}
+
+var filesystems = map[string]*Filesystem{}
+
+func init() {
+	ext := func(magic int64) *Filesystem {
+		return &Filesystem{
+			Magic: magic,
+			// Zero blocks are reserved for the super-user
+			MkfsArgs: []string{"-F", "-m0"},
+			MkfsFlags: map[string]bool{
+				"-b": true, "-C": true, "-E": true, "-G": true, "-g": true,
+				"-I": true, "-i": true, "-J": true, "-L": true, "-m": true,
+				"-N": true, "-O": true, "-T": true, "-U": true, "-j": false,
+			},
+			ReadOnlyMountOptions: []string{"noload"},
+			GroupMountOptions:    []string{"grpid"},
+			Grow: func(devicePath, _ string) error {
+				return (&ISCSIUtil{}).ResizeExt4(devicePath)
+			},
+			Fsck: e2fsck,
+		}
+	}
+	RegisterFilesystem("ext3", ext(0xEF53))
+	RegisterFilesystem("ext4", ext(0xEF53))
+	RegisterFilesystem("xfs", &Filesystem{
+		Magic: 0x58465342,
+		MkfsFlags: map[string]bool{
+			"-b": true, "-d": true, "-i": true, "-l": true, "-L": true,
+			"-m": true, "-n": true, "-r": true, "-s": true, "-K": false,
+		},
+		ReadOnlyMountOptions: []string{"norecovery"},
+		GroupMountOptions:    []string{"grpid"},
+		Grow: func(_, mountPath string) error {
+			return (&ISCSIUtil{}).ResizeXFS(mountPath)
+		},
+		Fsck: xfsRepair,
+	})
+	RegisterFilesystem("btrfs", &Filesystem{
+		Magic: 0x9123683E,
+		MkfsFlags: map[string]bool{
+			"-d": true, "-L": true, "-m": true, "-n": true, "-O": true,
+			"-R": true, "-s": true, "-U": true, "-K": false, "-M": false,
+		},
+		ReadOnlyMountOptions: []string{"nologreplay"},
+		Grow: func(_, mountPath string) error {
+			return (&ISCSIUtil{}).ResizeBtrfs(mountPath)
+		},
+		Fsck:          btrfsCheck,
+		DynamicInodes: true,
+	})
+}
+
+// RegisterFilesystem adds support for the given filesystem type
+func RegisterFilesystem(fsType string, fs *Filesystem) {
+	filesystems[fsType] = fs
+}
+
+// LookupFilesystem returns the handler of the given filesystem type
+func LookupFilesystem(fsType string) (*Filesystem, bool) {
+	fs, ok := filesystems[fsType]
+	return fs, ok
+}
+
+// LookupFilesystemByMagic returns the handler of the filesystem with the
+// given statfs type
+func LookupFilesystemByMagic(magic int64) (*Filesystem, bool) {
+	for _, fs := range filesystems {
+		if fs.Magic == magic {
+			return fs, true
+		}
+	}
+	return nil, false
+}
+
+// FilesystemTypes returns the supported filesystem types in sorted order
+func FilesystemTypes() []string {
+	types := make([]string, 0, len(filesystems))
+	for fsType := range filesystems {
+		types = append(types, fsType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Signature is what blkid finds at the start of a device
+type Signature struct {
+	// Type is the filesystem or other content type, e.g. LVM2_member
+	Type string
+	// PTType is the type of the partition table
+	PTType string
+}
+
+// Blank returns true if no signature was found on the device
+func (s Signature) Blank() bool {
+	return s.Type == "" && s.PTType == ""
+}
+
+func (s Signature) String() string {
+	switch {
+	case s.PTType != "":
+		return s.PTType + " partition table"
+	case s.Type != "":
+		return s.Type
+	}
+	return "no signature"
+}
+
+// ProbeDevice looks up the signature of the device with blkid, bypassing
+// its cache
+func ProbeDevice(devicePath string) (Signature, error) {
+	var sig Signature
+	out, err := utilexec.New().Command("blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", devicePath).CombinedOutput()
+	if err != nil {
+		if ignoreExitCodes(err, exitBlkidNoMatch) == nil {
+			return sig, nil
+		}
+		return sig, fmt.Errorf("failed to probe %s: %s (%v)", devicePath, string(out), err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "TYPE":
+			sig.Type = value
+		case "PTTYPE":
+			sig.PTType = value
+		}
+	}
+	return sig, nil
+}
+
+// DetectFilesystem returns the filesystem type the device holds as
+// reported by blkid, or an empty string if the device is blank
+func DetectFilesystem(devicePath string) (string, error) {
+	sig, err := ProbeDevice(devicePath)
+	return sig.Type, err
+}
+
+// ValidateMkfsOptions verifies that the given mkfs options only set flags
+// which are known for the filesystem type. Flags which pick the device or
+// change how mkfs runs, e.g. a dry run, are rejected.
+func ValidateMkfsOptions(fsType string, options []string) error {
+	fs, ok := LookupFilesystem(fsType)
+	if !ok {
+		return fmt.Errorf("unsupported filesystem type %q", fsType)
+	}
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		if len(option) < 2 || option[0] != '-' || option[1] == '-' {
+			return fmt.Errorf("unexpected mkfs.%s argument %q", fsType, option)
+		}
+		// The value may be given in the same argument as in -m0
+		flag := option[:2]
+		takesValue, ok := fs.MkfsFlags[flag]
+		if !ok {
+			return fmt.Errorf("mkfs.%s option %s is not supported", fsType, flag)
+		}
+		if !takesValue {
+			if len(option) > 2 {
+				return fmt.Errorf("mkfs.%s option %s takes no value", fsType, flag)
+			}
+			continue
+		}
+		if len(option) == 2 {
+			if i+1 == len(options) {
+				return fmt.Errorf("mkfs.%s option %s is missing a value", fsType, flag)
+			}
+			i++
+		}
+	}
+	return nil
+}
+
+// FormatDevice creates a filesystem of the given type on the device, the
+// given mkfs options are passed after the default ones of the filesystem
+func FormatDevice(devicePath, fsType string, options []string) error {
+	fs, ok := LookupFilesystem(fsType)
+	if !ok {
+		return fmt.Errorf("unsupported filesystem type %q", fsType)
+	}
+	args := append(append([]string{}, fs.MkfsArgs...), options...)
+	args = append(args, devicePath)
+	logrus.Infof("iscsi: formatting %s as %s with %v", devicePath, fsType, args)
+	out, err := utilexec.New().Command("mkfs."+fsType, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to format %s as %s: %s (%v)", devicePath, fsType, string(out), err)
+	}
+	return nil
+}
+
+// GrowFilesystem grows the filesystem of the device mounted at the given
+// path. The filesystem type is detected on the device, the type recorded
+// for the volume may be empty or stale.
+func GrowFilesystem(devicePath, mountPath string) error {
+	fsType, err := DetectFilesystem(devicePath)
+	if err != nil {
+		return err
+	}
+	fs, ok := LookupFilesystem(fsType)
+	if !ok {
+		return fmt.Errorf("resize of %q filesystem on %s is not supported", fsType, devicePath)
+	}
+	return fs.Grow(devicePath, mountPath)
+}
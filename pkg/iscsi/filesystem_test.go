@@ -0,0 +1,129 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilesystemTypes(t *testing.T) {
+	want := []string{"btrfs", "ext3", "ext4", "xfs"}
+	if got := FilesystemTypes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("FilesystemTypes() = %v, want %v", got, want)
+	}
+}
+
+func TestLookupFilesystem(t *testing.T) {
+	tests := map[string]struct {
+		fsType        string
+		found         bool
+		readOnlyOpts  []string
+		groupOpts     []string
+		dynamicInodes bool
+	}{
+		"ext3":  {fsType: "ext3", found: true, readOnlyOpts: []string{"noload"}, groupOpts: []string{"grpid"}},
+		"ext4":  {fsType: "ext4", found: true, readOnlyOpts: []string{"noload"}, groupOpts: []string{"grpid"}},
+		"xfs":   {fsType: "xfs", found: true, readOnlyOpts: []string{"norecovery"}, groupOpts: []string{"grpid"}},
+		"btrfs": {fsType: "btrfs", found: true, readOnlyOpts: []string{"nologreplay"}, dynamicInodes: true},
+		"ext2":  {fsType: "ext2", found: false},
+		"empty": {fsType: "", found: false},
+	}
+	for name, test := range tests {
+		fs, found := LookupFilesystem(test.fsType)
+		if found != test.found {
+			t.Errorf("%s: LookupFilesystem(%q) found = %v, want %v", name, test.fsType, found, test.found)
+			continue
+		}
+		if !found {
+			continue
+		}
+		if !reflect.DeepEqual(fs.ReadOnlyMountOptions, test.readOnlyOpts) {
+			t.Errorf("%s: read-only mount options = %v, want %v", name, fs.ReadOnlyMountOptions, test.readOnlyOpts)
+		}
+		if !reflect.DeepEqual(fs.GroupMountOptions, test.groupOpts) {
+			t.Errorf("%s: group mount options = %v, want %v", name, fs.GroupMountOptions, test.groupOpts)
+		}
+		if fs.DynamicInodes != test.dynamicInodes {
+			t.Errorf("%s: dynamic inodes = %v, want %v", name, fs.DynamicInodes, test.dynamicInodes)
+		}
+		if fs.Grow == nil {
+			t.Errorf("%s: filesystem can't be grown", name)
+		}
+		if fs.Fsck == nil {
+			t.Errorf("%s: filesystem can't be checked", name)
+		}
+	}
+}
+
+func TestLookupFilesystemByMagic(t *testing.T) {
+	btrfs, _ := LookupFilesystem("btrfs")
+	if fs, ok := LookupFilesystemByMagic(0x9123683E); !ok || fs != btrfs {
+		t.Errorf("LookupFilesystemByMagic(btrfs) = %v, %v, want the btrfs handler", fs, ok)
+	}
+	if _, ok := LookupFilesystemByMagic(0x6969); ok {
+		t.Errorf("LookupFilesystemByMagic(nfs) found a handler")
+	}
+}
+
+func TestValidateMkfsOptions(t *testing.T) {
+	tests := map[string]struct {
+		fsType  string
+		options []string
+		wantErr bool
+	}{
+		"no options":          {fsType: "ext4", options: nil},
+		"ext4 extended":       {fsType: "ext4", options: []string{"-E", "lazy_itable_init=0", "-m", "0"}},
+		"ext4 inline value":   {fsType: "ext4", options: []string{"-m0", "-j"}},
+		"xfs stripe":          {fsType: "xfs", options: []string{"-d", "su=64k,sw=4", "-i", "size=512", "-m", "reflink=1"}},
+		"btrfs profile":       {fsType: "btrfs", options: []string{"-m", "single", "-K"}},
+		"missing value":       {fsType: "xfs", options: []string{"-d"}, wantErr: true},
+		"value for a switch":  {fsType: "btrfs", options: []string{"-Kx"}, wantErr: true},
+		"unknown flag":        {fsType: "ext4", options: []string{"-n"}, wantErr: true},
+		"long flag":           {fsType: "xfs", options: []string{"--help"}, wantErr: true},
+		"device argument":     {fsType: "ext4", options: []string{"/dev/sda"}, wantErr: true},
+		"unsupported fs type": {fsType: "ext2", options: []string{"-m", "0"}, wantErr: true},
+	}
+	for name, test := range tests {
+		err := ValidateMkfsOptions(test.fsType, test.options)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: ValidateMkfsOptions(%s, %v) = %v, want error %v",
+				name, test.fsType, test.options, err, test.wantErr)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	tests := map[string]struct {
+		sig       Signature
+		wantBlank bool
+		wantDesc  string
+	}{
+		"blank":           {sig: Signature{}, wantBlank: true, wantDesc: "no signature"},
+		"filesystem":      {sig: Signature{Type: "xfs"}, wantDesc: "xfs"},
+		"lvm":             {sig: Signature{Type: "LVM2_member"}, wantDesc: "LVM2_member"},
+		"partition table": {sig: Signature{PTType: "gpt"}, wantDesc: "gpt partition table"},
+	}
+	for name, test := range tests {
+		if got := test.sig.Blank(); got != test.wantBlank {
+			t.Errorf("%s: Blank() = %v, want %v", name, got, test.wantBlank)
+		}
+		if got := test.sig.String(); got != test.wantDesc {
+			t.Errorf("%s: String() = %q, want %q", name, got, test.wantDesc)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	utilexec "k8s.io/utils/exec"
+)
+
+// FsckPolicy tells how the filesystem of a volume is checked before it is
+// mounted
+type FsckPolicy string
+
+const (
+	// FsckNever mounts the filesystem without checking it
+	FsckNever FsckPolicy = "never"
+	// FsckCheck checks the filesystem without modifying it, the mount is
+	// refused if errors are found
+	FsckCheck FsckPolicy = "check"
+	// FsckRepairSafe repairs the errors which can be fixed without losing
+	// data, such as the ones fsck fixes in preen mode
+	FsckRepairSafe FsckPolicy = "repair-safe"
+	// FsckRepairForce repairs all the errors, possibly losing the most
+	// recent changes, e.g. by zeroing the xfs log
+	FsckRepairForce FsckPolicy = "repair-force"
+)
+
+// e2fsck exit codes
+const (
+	e2fsckCorrected       = 1
+	e2fsckCorrectedReboot = 2
+	e2fsckUncorrected     = 4
+)
+
+// xfsRepairDirtyLog is the exit code of xfs_repair when the log has to be
+// replayed by mounting the filesystem before it can be repaired
+const xfsRepairDirtyLog = 2
+
+// xfsRepairLogIgnored is part of the warning xfs_repair prints in no-modify
+// mode when the log of the filesystem is dirty
+const xfsRepairLogIgnored = "log which is being ignored"
+
+// FsckResult is the outcome of checking a filesystem
+type FsckResult struct {
+	Policy FsckPolicy
+	// Repaired is set if errors were found and fixed, only e2fsck tells
+	// it apart from a clean filesystem
+	Repaired bool
+	// Unrecoverable is set if errors were left on the filesystem
+	Unrecoverable bool
+	// LogReplayPending is set if the journal or log of the filesystem has
+	// to be replayed, which is left to the mount. The filesystem can't be
+	// checked until then without spurious errors.
+	LogReplayPending bool
+	Output           string
+}
+
+func (r *FsckResult) String() string {
+	switch {
+	case r.Unrecoverable:
+		return fmt.Sprintf("%s: errors left on the filesystem", r.Policy)
+	case r.LogReplayPending:
+		return fmt.Sprintf("%s: log replay pending", r.Policy)
+	case r.Repaired:
+		return fmt.Sprintf("%s: errors repaired", r.Policy)
+	}
+	return fmt.Sprintf("%s: no errors left", r.Policy)
+}
+
+// ValidFsckPolicy returns true if the given fsck policy is known
+func ValidFsckPolicy(policy FsckPolicy) bool {
+	switch policy {
+	case FsckNever, FsckCheck, FsckRepairSafe, FsckRepairForce:
+		return true
+	}
+	return false
+}
+
+// CheckFilesystem checks, and repairs as allowed by the policy, the
+// filesystem of the given type on the unmounted device. An error is only
+// returned if the check could not be run.
+func CheckFilesystem(devicePath, fsType string, policy FsckPolicy) (*FsckResult, error) {
+	fs, ok := LookupFilesystem(fsType)
+	if !ok || fs.Fsck == nil {
+		return nil, fmt.Errorf("check of %q filesystem is not supported", fsType)
+	}
+	logrus.Infof("iscsi: checking %s filesystem on %s with policy %s", fsType, devicePath, policy)
+	return fs.Fsck(devicePath, policy)
+}
+
+// e2fsck checks an ext3 or ext4 filesystem. A journal which has to be
+// recovered is left to the mount under the check policy, e2fsck only
+// recovers it when allowed to modify the filesystem.
+func e2fsck(devicePath string, policy FsckPolicy) (*FsckResult, error) {
+	if policy == FsckCheck {
+		out, err := utilexec.New().Command("dumpe2fs", "-h", devicePath).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read superblock of %s: %v, output: %s", devicePath, err, out)
+		}
+		if extNeedsRecovery(string(out)) {
+			logrus.Infof("iscsi: journal of %s will be recovered by the mount", devicePath)
+			return &FsckResult{Policy: policy, LogReplayPending: true,
+				Output: "journal needs recovery, filesystem not checked"}, nil
+		}
+	}
+	args := map[FsckPolicy][]string{
+		FsckCheck:       {"-f", "-n"},
+		FsckRepairSafe:  {"-p"},
+		FsckRepairForce: {"-f", "-y"},
+	}[policy]
+	result, code, err := runFsck(policy, "e2fsck", append(args, devicePath)...)
+	switch {
+	case err != nil:
+		return nil, err
+	case code == e2fsckCorrected || code == e2fsckCorrectedReboot:
+		result.Repaired = true
+	case code&e2fsckUncorrected != 0:
+		result.Unrecoverable = true
+	case code != 0:
+		return nil, fmt.Errorf("e2fsck failed with exit code %d: %s", code, result.Output)
+	}
+	return result, nil
+}
+
+// xfsRepair checks an xfs filesystem. A log which has to be replayed is
+// left to the mount under the repair-safe policy, it is only zeroed under
+// the repair-force policy.
+func xfsRepair(devicePath string, policy FsckPolicy) (*FsckResult, error) {
+	args := map[FsckPolicy][]string{
+		FsckCheck:       {"-n"},
+		FsckRepairSafe:  {},
+		FsckRepairForce: {"-L"},
+	}[policy]
+	result, code, err := runFsck(policy, "xfs_repair", append(args, devicePath)...)
+	if err != nil {
+		return nil, err
+	}
+	// The errors found without replaying a dirty log are spurious
+	if (policy == FsckRepairSafe && code == xfsRepairDirtyLog) ||
+		(policy == FsckCheck && strings.Contains(result.Output, xfsRepairLogIgnored)) {
+		logrus.Infof("iscsi: xfs log of %s will be replayed by the mount", devicePath)
+		result.LogReplayPending = true
+		return result, nil
+	}
+	result.Unrecoverable = code != 0
+	return result, nil
+}
+
+// extNeedsRecovery returns true if the superblock printed by dumpe2fs has
+// the needs_recovery feature, which is set until the journal is recovered
+func extNeedsRecovery(superblock string) bool {
+	for _, line := range strings.Split(superblock, "\n") {
+		if strings.HasPrefix(line, "Filesystem features:") {
+			for _, feature := range strings.Fields(strings.TrimPrefix(line, "Filesystem features:")) {
+				if feature == "needs_recovery" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// btrfsCheck checks a btrfs filesystem. The offline repair of btrfs is
+// never safe, the filesystem is only checked under the repair-safe policy
+// and recovers from an unclean shutdown on mount.
+func btrfsCheck(devicePath string, policy FsckPolicy) (*FsckResult, error) {
+	args := []string{"check", "--readonly"}
+	if policy == FsckRepairForce {
+		args = []string{"check", "--repair"}
+	}
+	result, code, err := runFsck(policy, "btrfs", append(args, devicePath)...)
+	if err != nil {
+		return nil, err
+	}
+	result.Unrecoverable = code != 0
+	return result, nil
+}
+
+// runFsck runs the given check command and returns its output and exit
+// code, an error is returned if the command could not be run
+func runFsck(policy FsckPolicy, cmd string, args ...string) (*FsckResult, int, error) {
+	out, err := utilexec.New().Command(cmd, args...).CombinedOutput()
+	result := &FsckResult{Policy: policy, Output: strings.TrimSpace(string(out))}
+	if err == nil {
+		return result, 0, nil
+	}
+	if exitErr, ok := err.(utilexec.ExitError); ok {
+		return result, exitErr.ExitStatus(), nil
+	}
+	return nil, 0, fmt.Errorf("failed to run %s: %v", cmd, err)
+}
@@ -0,0 +1,75 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import "testing"
+
+func TestValidFsckPolicy(t *testing.T) {
+	for _, policy := range []FsckPolicy{FsckNever, FsckCheck, FsckRepairSafe, FsckRepairForce} {
+		if !ValidFsckPolicy(policy) {
+			t.Errorf("ValidFsckPolicy(%s) = false, want true", policy)
+		}
+	}
+	for _, policy := range []FsckPolicy{"", "repair", "Check"} {
+		if ValidFsckPolicy(policy) {
+			t.Errorf("ValidFsckPolicy(%q) = true, want false", policy)
+		}
+	}
+}
+
+func TestFsckResultString(t *testing.T) {
+	tests := map[string]struct {
+		result FsckResult
+		want   string
+	}{
+		"clean":         {result: FsckResult{Policy: FsckCheck}, want: "check: no errors left"},
+		"repaired":      {result: FsckResult{Policy: FsckRepairSafe, Repaired: true}, want: "repair-safe: errors repaired"},
+		"unrecoverable": {result: FsckResult{Policy: FsckCheck, Unrecoverable: true}, want: "check: errors left on the filesystem"},
+		"log replay":    {result: FsckResult{Policy: FsckCheck, LogReplayPending: true}, want: "check: log replay pending"},
+	}
+	for name, test := range tests {
+		if got := test.result.String(); got != test.want {
+			t.Errorf("%s: String() = %q, want %q", name, got, test.want)
+		}
+	}
+}
+
+func TestExtNeedsRecovery(t *testing.T) {
+	tests := map[string]struct {
+		superblock string
+		want       bool
+	}{
+		"clean": {
+			superblock: "Filesystem volume name:   <none>\n" +
+				"Filesystem features:      has_journal ext_attr resize_inode dir_index filetype extent 64bit\n" +
+				"Filesystem state:         clean\n",
+			want: false,
+		},
+		"journal to recover": {
+			superblock: "Filesystem volume name:   <none>\n" +
+				"Filesystem features:      has_journal ext_attr resize_inode dir_index filetype needs_recovery extent\n" +
+				"Filesystem state:         clean\n",
+			want: true,
+		},
+		"no features": {superblock: "Journal features:         needs_recovery\n", want: false},
+	}
+	for name, test := range tests {
+		if got := extNeedsRecovery(test.superblock); got != test.want {
+			t.Errorf("%s: extNeedsRecovery() = %v, want %v", name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	utilexec "k8s.io/utils/exec"
+	"k8s.io/utils/keymutex"
+)
+
+const (
+	// defaultIface is the iscsiadm iface which is present on every host
+	// and logs in over the route picked by the kernel
+	defaultIface = "default"
+
+	// boundIfacePrefix is the prefix of the iscsiadm ifaces created to bind
+	// the logins to a host network interface
+	boundIfacePrefix = "cstor-"
+)
+
+// ifaceLocks serialises the creation of iscsiadm ifaces which are shared
+// by all the volumes using the same host network interface
+var ifaceLocks = keymutex.NewHashed(0)
+
+// ResolveHostInterface returns the name of the host network interface
+// identified by the given value, which is either the name of the interface
+// or a CIDR one of its addresses belongs to
+func ResolveHostInterface(value string) (string, error) {
+	if strings.Contains(value, "/") {
+		_, cidr, err := net.ParseCIDR(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid host interface CIDR %s: %v", value, err)
+		}
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return "", fmt.Errorf("failed to list host interfaces: %v", err)
+		}
+		for _, iface := range ifaces {
+			addrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				if ipnet, ok := addr.(*net.IPNet); ok && cidr.Contains(ipnet.IP) {
+					return iface.Name, nil
+				}
+			}
+		}
+		return "", fmt.Errorf("no host interface has an address in %s", value)
+	}
+	if _, err := net.InterfaceByName(value); err != nil {
+		return "", fmt.Errorf("host interface %s not found: %v", value, err)
+	}
+	return value, nil
+}
+
+// BoundIfaceName returns the name of the iscsiadm iface bound to the given
+// host network interface
+func BoundIfaceName(netIface string) string {
+	return boundIfacePrefix + netIface
+}
+
+// BindIface creates, or reuses if already present, the iscsiadm iface bound
+// to the given host network interface and returns its name. The iface is
+// cloned from the default iface with iface.net_ifacename set.
+func BindIface(netIface string) (string, error) {
+	if _, err := net.InterfaceByName(netIface); err != nil {
+		return "", fmt.Errorf("host interface %s not found: %v", netIface, err)
+	}
+	name := BoundIfaceName(netIface)
+	ifaceLocks.LockKey(name)
+	defer ifaceLocks.UnlockKey(name)
+
+	b := iscsiDiskMounter{
+		iscsiDisk: &iscsiDisk{Iface: defaultIface, netIface: netIface},
+		exec:      utilexec.New(),
+	}
+	out, err := b.exec.Command(
+		"iscsiadm", "-m", "iface",
+		"-I", name, "-o", "show",
+	).CombinedOutput()
+	if err == nil {
+		params, err := parseIscsiadmShow(string(out))
+		if err != nil {
+			return "", fmt.Errorf("iscsi: failed to parse iface %s: %v", name, err)
+		}
+		if params["iface.net_ifacename"] == netIface {
+			return name, nil
+		}
+		out, err = b.exec.Command(
+			"iscsiadm", "-m", "iface", "-I", name,
+			"-o", "update", "-n", "iface.net_ifacename", "-v", netIface,
+		).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf(
+				"iscsi: failed to bind iface %s to %s: %s (%v)",
+				name, netIface, string(out), err,
+			)
+		}
+		return name, nil
+	}
+
+	logrus.Infof("iscsi: creating iface %s bound to host interface %s", name, netIface)
+	if err := cloneIface(b, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,57 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"net"
+	"testing"
+)
+
+func TestResolveHostInterface(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("loopback interface not present: %v", err)
+	}
+	tests := map[string]struct {
+		value   string
+		want    string
+		isError bool
+	}{
+		"interface name":    {value: lo.Name, want: lo.Name},
+		"cidr of interface": {value: "127.0.0.0/8", want: lo.Name},
+		"missing interface": {value: "cstor-missing0", isError: true},
+		"invalid cidr":      {value: "10.0.0.0/33", isError: true},
+	}
+	for name, test := range tests {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			got, err := ResolveHostInterface(test.value)
+			if test.isError {
+				if err == nil {
+					t.Fatalf("expected error for %s, got interface %s", test.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %v", test.value, err)
+			}
+			if got != test.want {
+				t.Fatalf("expected interface %s, got %s", test.want, got)
+			}
+		})
+	}
+}
@@ -52,6 +52,10 @@ type iscsiDisk struct {
 	secret        map[string]string
 	InitiatorName string
 	VolName       string
+	// sessionParams are the iscsiadm node settings applied before login
+	sessionParams map[string]string
+	// netIface is the host network interface the cloned iface is bound to
+	netIface string
 }
 
 type iscsiPlugin struct {
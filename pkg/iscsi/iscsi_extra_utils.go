@@ -18,6 +18,7 @@ package iscsi
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
@@ -25,6 +26,15 @@ import (
 	"k8s.io/utils/mount"
 )
 
+// The volume attributes of a statically provisioned PV which override the
+// iSCSI details of its CStorVolume
+const (
+	TargetPortalKey   = "targetPortal"
+	IqnKey            = "iqn"
+	LunKey            = "lun"
+	ISCSIInterfaceKey = "iscsiInterface"
+)
+
 func getISCSIInfo(vol *apis.CStorVolumeAttachment) (*iscsiDisk, error) {
 	portal := portalMounter(vol.Spec.ISCSI.TargetPortal)
 	var portals []string
@@ -44,45 +54,62 @@ func getISCSIInfo(vol *apis.CStorVolumeAttachment) (*iscsiDisk, error) {
 	}, nil
 }
 
-func getISCSIInfoFromPV(req *csi.NodePublishVolumeRequest) (*iscsiDisk, error) {
-	volName := req.GetVolumeId()
-	tp := req.GetVolumeContext()["targetPortal"]
-	iqn := req.GetVolumeContext()["iqn"]
-	lun := req.GetVolumeContext()["lun"]
-	if tp == "" || iqn == "" || lun == "" {
-		return nil, fmt.Errorf("iSCSI target information is missing")
-	}
-
-	//portalList := req.GetVolumeContext()["portals"]
-	secretParams := req.GetVolumeContext()["secret"]
-	secret := parseSecret(secretParams)
-
-	portal := portalMounter(tp)
+// getISCSIInfoFromPV returns the iSCSI details set in the volume attributes
+// of a statically provisioned PV, the ones which are not set are left empty
+func getISCSIInfoFromPV(volName string, volCtx map[string]string) (*iscsiDisk, error) {
 	var portals []string
-	portals = append(portals, portal)
-
-	iface := req.GetVolumeContext()["iscsiInterface"]
-	initiatorName := req.GetVolumeContext()["initiatorName"]
-	chapDiscovery := false
-	if req.GetVolumeContext()["discoveryCHAPAuth"] == "true" {
-		chapDiscovery = true
+	if tp := volCtx[TargetPortalKey]; tp != "" {
+		portals = append(portals, portalMounter(tp))
 	}
-
-	chapSession := false
-	if req.GetVolumeContext()["sessionCHAPAuth"] == "true" {
-		chapSession = true
+	lun := volCtx[LunKey]
+	if lun != "" {
+		if _, err := strconv.ParseUint(lun, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid lun %q: %v", lun, err)
+		}
 	}
 
+	//portalList := volCtx["portals"]
+	secret := parseSecret(volCtx["secret"])
+
 	return &iscsiDisk{
 		VolName:       volName,
 		Portals:       portals,
-		Iqn:           iqn,
+		Iqn:           volCtx[IqnKey],
 		lun:           lun,
-		Iface:         iface,
-		chapDiscovery: chapDiscovery,
-		chapSession:   chapSession,
+		Iface:         volCtx[ISCSIInterfaceKey],
+		chapDiscovery: volCtx["discoveryCHAPAuth"] == "true",
+		chapSession:   volCtx["sessionCHAPAuth"] == "true",
 		secret:        secret,
-		InitiatorName: initiatorName}, nil
+		InitiatorName: volCtx["initiatorName"]}, nil
+}
+
+// ApplyVolumeContext overrides the iSCSI details of the CVA, which are
+// filled from its CStorVolume, with the ones set in the volume attributes
+// of a statically provisioned PV
+func ApplyVolumeContext(vol *apis.CStorVolumeAttachment, volCtx map[string]string) error {
+	info, err := getISCSIInfoFromPV(vol.Spec.Volume.Name, volCtx)
+	if err != nil {
+		return err
+	}
+	// Only the details recorded on the CVA are used by the logins which
+	// happen after a restart of the node plugin
+	if info.chapDiscovery || info.chapSession || info.InitiatorName != "" {
+		return fmt.Errorf("CHAP authentication and initiator names are not supported for volume %s",
+			info.VolName)
+	}
+	if len(info.Portals) != 0 {
+		vol.Spec.ISCSI.TargetPortal = info.Portals[0]
+	}
+	if info.Iqn != "" {
+		vol.Spec.ISCSI.Iqn = info.Iqn
+	}
+	if info.lun != "" {
+		vol.Spec.ISCSI.Lun = info.lun
+	}
+	if info.Iface != "" {
+		vol.Spec.ISCSI.IscsiInterface = info.Iface
+	}
+	return nil
 }
 
 func getISCSIDiskUnmounter(req *csi.NodeUnpublishVolumeRequest) *iscsiDiskUnmounter {
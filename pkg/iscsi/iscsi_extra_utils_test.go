@@ -0,0 +1,91 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"testing"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+)
+
+func TestApplyVolumeContext(t *testing.T) {
+	fromCV := apis.ISCSIInfo{
+		Iqn:            "iqn.2016-09.com.openebs.cstor:pvc-1",
+		TargetPortal:   "10.0.0.1:3260",
+		IscsiInterface: "default",
+		Lun:            "0",
+	}
+	tests := map[string]struct {
+		volCtx  map[string]string
+		want    apis.ISCSIInfo
+		wantErr bool
+	}{
+		"no attributes": {
+			volCtx: map[string]string{"openebs.io/cas-type": "cstor"},
+			want:   fromCV,
+		},
+		"portal without port": {
+			volCtx: map[string]string{"targetPortal": "10.0.0.2"},
+			want: apis.ISCSIInfo{
+				Iqn:            fromCV.Iqn,
+				TargetPortal:   "10.0.0.2:3260",
+				IscsiInterface: fromCV.IscsiInterface,
+				Lun:            fromCV.Lun,
+			},
+		},
+		"all attributes": {
+			volCtx: map[string]string{
+				"targetPortal":   "10.0.0.2:3261",
+				"iqn":            "iqn.2016-09.com.openebs.cstor:restored",
+				"lun":            "1",
+				"iscsiInterface": "eth1",
+			},
+			want: apis.ISCSIInfo{
+				Iqn:            "iqn.2016-09.com.openebs.cstor:restored",
+				TargetPortal:   "10.0.0.2:3261",
+				IscsiInterface: "eth1",
+				Lun:            "1",
+			},
+		},
+		"invalid lun": {
+			volCtx:  map[string]string{"lun": "first"},
+			wantErr: true,
+		},
+		"chap": {
+			volCtx:  map[string]string{"sessionCHAPAuth": "true"},
+			wantErr: true,
+		},
+		"initiator name": {
+			volCtx:  map[string]string{"initiatorName": "iqn.2004-10.org.debian:node1"},
+			wantErr: true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			vol := &apis.CStorVolumeAttachment{}
+			vol.Spec.Volume.Name = "pvc-1"
+			vol.Spec.ISCSI = fromCV
+			err := ApplyVolumeContext(vol, test.volCtx)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ApplyVolumeContext() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err == nil && vol.Spec.ISCSI != test.want {
+				t.Errorf("ApplyVolumeContext() = %+v, want %+v", vol.Spec.ISCSI, test.want)
+			}
+		})
+	}
+}
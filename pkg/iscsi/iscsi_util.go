@@ -225,6 +225,60 @@ func (util *ISCSIUtil) loadISCSI(conf *iscsiDisk, mnt string) error {
 // AttachDisk logs in to the iSCSI volume and returns the corresponding diskPath
 // of the volume which gets created on the node
 func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
+	devicePath, err := util.loginDisk(b)
+	if err != nil {
+		return "", err
+	}
+
+	// Mount device
+	mntPath := b.targetPath
+	notMnt, err := b.mounter.IsLikelyNotMountPoint(mntPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "",
+			fmt.Errorf(
+				"Heuristic determination of mount point failed:%v", err)
+	}
+	if !notMnt {
+		logrus.Infof("iscsi: %s already mounted", mntPath)
+		return "", nil
+	}
+
+	/*
+		if err := os.MkdirAll(mntPath, 0750); err != nil {
+			logrus.Errorf("iscsi: failed to mkdir %s, error", mntPath)
+			return "", err
+		}
+			// Persist iscsi disk config to json file for DetachDisk path
+			if err := util.persistISCSI(*(b.iscsiDisk), b.targetPath); err != nil {
+				logrus.Errorf("iscsi: failed to save iscsi config with error: %v", err)
+				return "", err
+			}
+	*/
+
+	var options []string
+
+	if b.readOnly {
+		options = append(options, "ro")
+	} else {
+		options = append(options, "rw")
+	}
+	options = append(options, b.mountOptions...)
+
+	err = b.mounter.FormatAndMount(devicePath, mntPath, b.fsType, options)
+	if err != nil {
+		logrus.Errorf(
+			"iscsi: failed to mount iscsi volume %s [%s] to %s, error %v",
+			devicePath, b.fsType, mntPath, err,
+		)
+	}
+
+	return devicePath, err
+}
+
+// loginDisk logs in to the portals of the iSCSI volume and returns the path
+// of the disk, or of the multipath device built on top of it, that gets
+// created on the node
+func (util *ISCSIUtil) loginDisk(b iscsiDiskMounter) (string, error) {
 	var devicePath string
 	var devicePaths []string
 	var iscsiTransport string
@@ -357,6 +411,14 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 			)
 			continue
 		}
+		err = updateISCSISessionParams(b, tp)
+		if err != nil {
+			lastErr = fmt.Errorf(
+				"iscsi: failed to tune iscsi node to portal %s error: %v",
+				tp, err,
+			)
+			continue
+		}
 
 		// login to iscsi target
 		out, err = b.exec.Command(
@@ -386,11 +448,14 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 	}
 
 	if len(devicePaths) == 0 {
-		// delete cloned iface
-		b.exec.Command(
-			"iscsiadm", "-m", "iface",
-			"-I", b.Iface, "-o", "delete",
-		).CombinedOutput()
+		// delete cloned iface, ifaces bound to a host network interface are
+		// shared with other volumes and are left as is
+		if b.InitiatorName != "" {
+			b.exec.Command(
+				"iscsiadm", "-m", "iface",
+				"-I", b.Iface, "-o", "delete",
+			).CombinedOutput()
+		}
 		logrus.Errorf(
 			"iscsi: failed to get any path for iscsi disk, last err seen:\n%v",
 			lastErr,
@@ -408,31 +473,6 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 	// Make sure we use a valid devicepath to find mpio device.
 	devicePath = devicePaths[0]
 
-	// Mount device
-	mntPath := b.targetPath
-	notMnt, err := b.mounter.IsLikelyNotMountPoint(mntPath)
-	if err != nil && !os.IsNotExist(err) {
-		return "",
-			fmt.Errorf(
-				"Heuristic determination of mount point failed:%v", err)
-	}
-	if !notMnt {
-		logrus.Infof("iscsi: %s already mounted", mntPath)
-		return "", nil
-	}
-
-	/*
-		if err := os.MkdirAll(mntPath, 0750); err != nil {
-			logrus.Errorf("iscsi: failed to mkdir %s, error", mntPath)
-			return "", err
-		}
-			// Persist iscsi disk config to json file for DetachDisk path
-			if err := util.persistISCSI(*(b.iscsiDisk), b.targetPath); err != nil {
-				logrus.Errorf("iscsi: failed to save iscsi config with error: %v", err)
-				return "", err
-			}
-	*/
-
 	for _, path := range devicePaths {
 		// There shouldnt be any empty device paths. However adding this check
 		// for safer side to avoid the possibility of an empty entry.
@@ -446,25 +486,7 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 			break
 		}
 	}
-
-	var options []string
-
-	if b.readOnly {
-		options = append(options, "ro")
-	} else {
-		options = append(options, "rw")
-	}
-	options = append(options, b.mountOptions...)
-
-	err = b.mounter.FormatAndMount(devicePath, mntPath, b.fsType, options)
-	if err != nil {
-		logrus.Errorf(
-			"iscsi: failed to mount iscsi volume %s [%s] to %s, error %v",
-			devicePath, b.fsType, mntPath, err,
-		)
-	}
-
-	return devicePath, err
+	return devicePath, nil
 }
 
 // DetachDisk logs out of the iSCSI volume and the corresponding path is removed
@@ -473,7 +495,7 @@ func (util *ISCSIUtil) DetachDisk(
 	targetPath string,
 ) error {
 
-	if pathExists, pathErr := mount.PathExists(targetPath); pathErr != nil {
+	if pathExists, pathErr := mount.PathExists(targetPath); pathErr != nil && !IsCorruptedMount(pathErr) {
 		return fmt.Errorf("Error checking if path exists: %v", pathErr)
 	} else if !pathExists {
 		logrus.Warningf("Warning: Unmount skipped because path does not exist: %v", targetPath)
@@ -481,6 +503,15 @@ func (util *ISCSIUtil) DetachDisk(
 	}
 
 	notMnt, err := c.mounter.IsLikelyNotMountPoint(targetPath)
+	if IsCorruptedMount(err) {
+		// The device behind the mount point is gone, the mount point
+		// can't be stat'ed anymore but it still needs to be released
+		// before logging out
+		if err := ForceUnmount(targetPath); err != nil {
+			return err
+		}
+		notMnt, err = true, nil
+	}
 	if err != nil {
 		return err
 	}
@@ -671,7 +702,13 @@ func cloneIface(b iscsiDiskMounter, newIface string) error {
 		return lastErr
 	}
 	// update initiatorname
-	params["iface.initiatorname"] = b.InitiatorName
+	if b.InitiatorName != "" {
+		params["iface.initiatorname"] = b.InitiatorName
+	}
+	// bind to the host network interface
+	if b.netIface != "" {
+		params["iface.net_ifacename"] = b.netIface
+	}
 	// create new iface
 	out, err = b.exec.Command("iscsiadm", "-m", "iface", "-I", newIface, "-o", "new").CombinedOutput()
 	if err != nil {
@@ -707,7 +744,9 @@ func (util *ISCSIUtil) UnmountDisk(
 	c iscsiDiskUnmounter,
 	targetPath string,
 ) error {
-	if pathExists, pathErr := mount.PathExists(targetPath); pathErr != nil {
+	if pathExists, pathErr := mount.PathExists(targetPath); IsCorruptedMount(pathErr) {
+		return ForceUnmount(targetPath)
+	} else if pathErr != nil {
 		return fmt.Errorf("Error checking if path exists: %v", pathErr)
 	} else if !pathExists {
 		logrus.Warningf(
@@ -760,3 +799,17 @@ func (util *ISCSIUtil) ResizeXFS(path string) error {
 	}
 	return nil
 }
+
+// ResizeBtrfs can be used to run a resize command on the btrfs filesystem
+// mounted at the given path to expand it to the actual size of the device
+func (util *ISCSIUtil) ResizeBtrfs(path string) error {
+	b := &iscsiDiskMounter{
+		exec: utilexec.New(),
+	}
+	out, err := b.exec.Command("btrfs", "filesystem", "resize", "max", path).CombinedOutput()
+	if err != nil {
+		logrus.Errorf("iscsi: resize failed error: %s", string(out))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// mapperDir is where device mapper exposes the opened LUKS devices
+	mapperDir = "/dev/mapper"
+
+	// exitBlkidNoMatch is the exit code of blkid when the device holds
+	// no known signature
+	exitBlkidNoMatch = 2
+
+	// exitCryptsetupNotActive is the exit code of cryptsetup when the
+	// device mapping does not exist
+	exitCryptsetupNotActive = 4
+)
+
+// ErrNotLUKSDevice is returned when a device to be opened as a LUKS device
+// holds some other signature, such as a plain filesystem
+var ErrNotLUKSDevice = errors.New("not a LUKS device")
+
+// LUKSDevicePath returns the path at which the LUKS device with the given
+// name is exposed once opened
+func LUKSDevicePath(name string) string {
+	return filepath.Join(mapperDir, name)
+}
+
+// OpenLUKS opens the LUKS device on top of the given device and returns the
+// path of the mapped device. The device is LUKS formatted first if it is
+// blank, a device holding any other signature is never formatted. Discards
+// are passed on to the device only if allowed, since they reveal which
+// blocks of the device are unused.
+func OpenLUKS(devicePath, name, passphrase string, allowDiscards bool) (string, error) {
+	mapped := LUKSDevicePath(name)
+	if _, err := os.Stat(mapped); err == nil {
+		return mapped, nil
+	}
+
+	exec := utilexec.New()
+	if err := exec.Command("cryptsetup", "isLuks", devicePath).Run(); err != nil {
+		sig, err := ProbeDevice(devicePath)
+		if err != nil {
+			return "", err
+		}
+		if !sig.Blank() {
+			return "", fmt.Errorf("device %s is %w, it holds %s",
+				devicePath, ErrNotLUKSDevice, sig)
+		}
+		logrus.Infof("luks: formatting %s", devicePath)
+		if err := cryptsetup(passphrase, "-q", "luksFormat", "--type", "luks2",
+			"--key-file", "-", devicePath); err != nil {
+			return "", err
+		}
+	}
+
+	logrus.Infof("luks: opening %s as %s", devicePath, mapped)
+	args := []string{"luksOpen", "--key-file", "-"}
+	if allowDiscards {
+		args = append(args, "--allow-discards")
+	}
+	if err := cryptsetup(passphrase, append(args, devicePath, name)...); err != nil {
+		return "", err
+	}
+	return mapped, nil
+}
+
+// CloseLUKS closes the LUKS device with the given name, it is a no-op if
+// the device is not open
+func CloseLUKS(name string) error {
+	out, err := utilexec.New().Command("cryptsetup", "luksClose", name).CombinedOutput()
+	if err = ignoreExitCodes(err, exitCryptsetupNotActive); err != nil {
+		return fmt.Errorf("failed to close LUKS device %s: %s (%v)", name, string(out), err)
+	}
+	return nil
+}
+
+// ResizeLUKS grows the LUKS device with the given name to the size of the
+// underlying device. The passphrase is only required if the volume key is
+// not held by the kernel keyring.
+func ResizeLUKS(name, passphrase string) error {
+	if passphrase == "" {
+		out, err := utilexec.New().Command("cryptsetup", "resize", name).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to resize LUKS device %s: %s (%v)", name, string(out), err)
+		}
+		return nil
+	}
+	return cryptsetup(passphrase, "resize", "--key-file", "-", name)
+}
+
+// cryptsetup runs cryptsetup with the passphrase fed through stdin so that
+// it never shows up in the process list
+func cryptsetup(passphrase string, args ...string) error {
+	cmd := utilexec.New().Command("cryptsetup", args...)
+	cmd.SetStdin(strings.NewReader(passphrase))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cryptsetup %s failed: %s (%v)", strings.Join(args, " "), string(out), err)
+	}
+	return nil
+}
@@ -17,11 +17,34 @@
 package iscsi
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
 	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"k8s.io/kubernetes/pkg/volume/util"
 	utilexec "k8s.io/utils/exec"
 	"k8s.io/utils/mount"
 )
 
+// AttachDisk applies the given iscsiadm node settings, logs in to the
+// iSCSI volume and returns the path of the device created on the node
+func AttachDisk(vol *apis.CStorVolumeAttachment, sessionParams map[string]string) (string, error) {
+	iscsiInfo, err := getISCSIInfo(vol)
+	if err != nil {
+		return "", err
+	}
+	iscsiInfo.sessionParams = sessionParams
+
+	diskMounter := iscsiDiskMounter{
+		iscsiDisk:  iscsiInfo,
+		exec:       utilexec.New(),
+		deviceUtil: util.NewDeviceHandler(util.NewIOHandler()),
+	}
+	iscsiUtil := &ISCSIUtil{}
+	return iscsiUtil.loginDisk(diskMounter)
+}
+
 // UnmountAndDetachDisk unmounts the disk from the specified path
 // and logs out of the iSCSI Volume
 func UnmountAndDetachDisk(vol *apis.CStorVolumeAttachment, path string) error {
@@ -54,29 +77,79 @@ func Unmount(path string) error {
 	return util.UnmountDisk(*diskUnmounter, path)
 }
 
-// ResizeVolume rescans the iSCSI session and runs the resize to filesystem
-// command on that particular device
-func ResizeVolume(volumePath string, vol *apis.CStorVolumeAttachment) error {
-	var err error
-	mounter := mount.New("")
-	list, _ := mounter.List()
-	for _, mpt := range list {
-		if mpt.Path == volumePath {
-			util := &ISCSIUtil{}
-			if err := util.ReScan(vol.Spec.ISCSI.Iqn, vol.Spec.ISCSI.TargetPortal); err != nil {
-				return err
-			}
-			switch vol.Spec.Volume.FSType {
-			case "ext4":
-				err = util.ResizeExt4(mpt.Device)
-			case "xfs":
-				err = util.ResizeXFS(volumePath)
-			}
-			if err != nil {
-				return err
+// ErrDeviceNotGrown is returned by ResizeVolume when the disk of the volume
+// is still smaller than the required size after the rescan
+var ErrDeviceNotGrown = errors.New("device has not grown to the required size")
+
+// ResizeVolume rescans the iSCSI session, waits for the disk of the volume
+// to grow to the required size and then grows the layers on top of it: the
+// multipath device, the LUKS device with the given name if any, and the
+// filesystem mounted at the volume path unless it is a raw block volume.
+// It returns the size of the top-most device as seen by the kernel, which
+// for an encrypted volume is smaller than the disk by the LUKS header.
+func ResizeVolume(
+	volumePath string,
+	vol *apis.CStorVolumeAttachment,
+	requiredBytes int64,
+	luksName, passphrase string,
+) (int64, error) {
+	var device string
+	if vol.Spec.Volume.AccessType != "block" {
+		list, err := mount.New("").List()
+		if err != nil {
+			return 0, err
+		}
+		for _, mpt := range list {
+			if mpt.Path == volumePath {
+				device = mpt.Device
+				break
 			}
-			break
+		}
+		if device == "" {
+			return 0, fmt.Errorf("volume path %s is not mounted", volumePath)
+		}
+	}
+
+	iscsiUtil := &ISCSIUtil{}
+	if err := iscsiUtil.ReScan(vol.Spec.ISCSI.Iqn, vol.Spec.ISCSI.TargetPortal); err != nil {
+		return 0, err
+	}
+	disk, err := filepath.EvalSymlinks(vol.Spec.Volume.DevicePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve disk of volume %s: %v", vol.Spec.Volume.Name, err)
+	}
+	size, err := waitForDeviceSize(disk, requiredBytes, resizeWaitTimeout)
+	if err != nil {
+		return 0, err
+	}
+	// Every path of a multipath device is rescanned along with the session
+	// of the IQN, the map picks up the new size once told to
+	deviceUtil := util.NewDeviceHandler(util.NewIOHandler())
+	if mpath := deviceUtil.FindMultipathDeviceForDevice(disk); mpath != "" {
+		if err := resizeMultipath(mpath); err != nil {
+			return 0, err
+		}
+		if size, err = waitForDeviceSize(mpath, requiredBytes, resizeWaitTimeout); err != nil {
+			return 0, err
+		}
+	}
+	if size < requiredBytes {
+		return 0, fmt.Errorf("%w: %s is %d bytes, %d bytes required",
+			ErrDeviceNotGrown, disk, size, requiredBytes)
+	}
+
+	if luksName != "" {
+		if err := ResizeLUKS(luksName, passphrase); err != nil {
+			return 0, err
+		}
+		if size, err = DeviceSize(LUKSDevicePath(luksName)); err != nil {
+			return 0, err
+		}
+	}
+	if device != "" {
+		if err := GrowFilesystem(device, volumePath); err != nil {
+			return 0, err
 		}
 	}
-	return nil
+	return size, nil
 }
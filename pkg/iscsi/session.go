@@ -0,0 +1,242 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// SessionStateLoggedIn is the iSCSI session state reported by
+	// iscsiadm when the session is usable
+	SessionStateLoggedIn = "LOGGED_IN"
+	// SessionStateFailed is the iSCSI session state reported by iscsiadm
+	// when the connection to the target has been lost and the kernel is
+	// trying to reinstate it
+	SessionStateFailed = "FAILED"
+	// ConnectionStateLoggedIn is the iSCSI connection state reported by
+	// iscsiadm when the connection is usable
+	ConnectionStateLoggedIn = "LOGGED IN"
+	// DeviceStateRunning is the state of a SCSI device which can serve IOs
+	DeviceStateRunning = "running"
+	// CStorIQNPrefix is the prefix of the IQN of every cStor target, it is
+	// followed by the name of the volume served by the target
+	CStorIQNPrefix = "iqn.2016-09.com.openebs.cstor:"
+)
+
+// SessionDevice is a SCSI disk attached through an iSCSI session
+type SessionDevice struct {
+	// Name of the disk e.g. sdb
+	Name string
+	// State of the disk e.g. running, offline, blocked
+	State string
+}
+
+// Session holds the details of an iSCSI session as reported by
+// `iscsiadm -m session -P 3`
+type Session struct {
+	SID              string
+	TargetIQN        string
+	CurrentPortal    string
+	PersistentPortal string
+	IfaceName        string
+	IfaceTransport   string
+	IfaceIPAddress   string
+	IfaceNetdev      string
+	ConnectionState  string
+	SessionState     string
+	Devices          []SessionDevice
+}
+
+// IsLoggedIn returns true if both the session and its connection are
+// logged in to the target
+func (s *Session) IsLoggedIn() bool {
+	return s.SessionState == SessionStateLoggedIn &&
+		s.ConnectionState == ConnectionStateLoggedIn
+}
+
+// IsFailed returns true if the kernel has marked the session as failed
+func (s *Session) IsFailed() bool {
+	return s.SessionState == SessionStateFailed
+}
+
+// HasPortal returns true if the session is connected to the given portal
+func (s *Session) HasPortal(portal string) bool {
+	portal = portalMounter(portal)
+	return s.PersistentPortal == portal || s.CurrentPortal == portal
+}
+
+// OfflineDevices returns the attached disks which are not in running state
+func (s *Session) OfflineDevices() []SessionDevice {
+	var devices []SessionDevice
+	for _, dev := range s.Devices {
+		if dev.State != DeviceStateRunning {
+			devices = append(devices, dev)
+		}
+	}
+	return devices
+}
+
+// VolumeName returns the name of the cStor volume the session is logged in
+// to, it is empty if the target is not a cStor target
+func (s *Session) VolumeName() string {
+	if !strings.HasPrefix(s.TargetIQN, CStorIQNPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(s.TargetIQN, CStorIQNPrefix)
+}
+
+// ListSessions returns all the iSCSI sessions present on the host
+func ListSessions() ([]Session, error) {
+	exec := utilexec.New()
+	out, err := exec.Command("iscsiadm", "-m", "session", "-P", "3").CombinedOutput()
+	if err != nil {
+		// iscsiadm exits with "no objects found" if there are no sessions
+		if ignoreExitCodes(err, exit_ISCSI_ERR_NO_OBJS_FOUND) == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("iscsi: failed to list sessions: %s (%v)", string(out), err)
+	}
+	return parseSessions(string(out)), nil
+}
+
+// ListSessionsForIQN returns the iSCSI sessions which are logged in to the
+// given target
+func ListSessionsForIQN(iqn string) ([]Session, error) {
+	sessions, err := ListSessions()
+	if err != nil {
+		return nil, err
+	}
+	var filtered []Session
+	for _, s := range sessions {
+		if s.TargetIQN == iqn {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered, nil
+}
+
+// parseSessions parses the output of `iscsiadm -m session -P 3`. Every
+// session of a target starts with a "Current Portal" line and the details
+// that follow belong to that session until the next one starts.
+func parseSessions(output string) []Session {
+	var (
+		sessions []Session
+		target   string
+		current  *Session
+	)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "Target:"):
+			fields := strings.Fields(strings.TrimPrefix(line, "Target:"))
+			if len(fields) > 0 {
+				target = fields[0]
+			}
+			continue
+		case strings.HasPrefix(line, "Current Portal:"):
+			sessions = append(sessions, Session{
+				TargetIQN:     target,
+				CurrentPortal: trimPortalGroupTag(valueOf(line)),
+			})
+			current = &sessions[len(sessions)-1]
+			continue
+		case strings.HasPrefix(line, "Attached scsi disk"):
+			if current == nil {
+				continue
+			}
+			// Attached scsi disk sdb          State: running
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				continue
+			}
+			dev := SessionDevice{Name: fields[3]}
+			if idx := strings.Index(line, "State:"); idx != -1 {
+				dev.State = strings.TrimSpace(line[idx+len("State:"):])
+			}
+			current.Devices = append(current.Devices, dev)
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		value := valueOf(line)
+		switch {
+		case strings.HasPrefix(line, "Persistent Portal:"):
+			current.PersistentPortal = trimPortalGroupTag(value)
+		case strings.HasPrefix(line, "Iface Name:"):
+			current.IfaceName = value
+		case strings.HasPrefix(line, "Iface Transport:"):
+			current.IfaceTransport = value
+		case strings.HasPrefix(line, "Iface IPaddress:"):
+			current.IfaceIPAddress = value
+		case strings.HasPrefix(line, "Iface Netdev:"):
+			current.IfaceNetdev = value
+		case strings.HasPrefix(line, "SID:"):
+			current.SID = value
+		case strings.HasPrefix(line, "iSCSI Connection State:"):
+			current.ConnectionState = value
+		case strings.HasPrefix(line, "iSCSI Session State:"):
+			current.SessionState = value
+		}
+	}
+	return sessions
+}
+
+// valueOf returns the trimmed value of a "key: value" line
+func valueOf(line string) string {
+	idx := strings.Index(line, ":")
+	if idx == -1 {
+		return ""
+	}
+	return strings.TrimSpace(line[idx+1:])
+}
+
+// trimPortalGroupTag removes the target portal group tag from the portal
+// i.e. 10.0.0.5:3260,1 becomes 10.0.0.5:3260
+func trimPortalGroupTag(portal string) string {
+	if idx := strings.LastIndex(portal, ","); idx != -1 {
+		return portal[:idx]
+	}
+	return portal
+}
+
+// LogoutSession logs out of the given target portal and removes the
+// corresponding node record
+func LogoutSession(iqn, portal string) error {
+	exec := utilexec.New()
+	util := &ISCSIUtil{}
+	return util.detachISCSIDisk(exec, []string{portalMounter(portal)}, iqn, "", "", "", false)
+}
+
+// RescanSession rescans the LUNs of the given iSCSI session
+func RescanSession(sid string) error {
+	exec := utilexec.New()
+	out, err := exec.Command("iscsiadm", "-m", "session", "-r", sid, "--rescan").CombinedOutput()
+	if err != nil {
+		logrus.Errorf("iscsi: rescan of session %s failed error: %s", sid, string(out))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const (
+	// ReplacementTimeout is the node setting which controls how long the
+	// kernel waits for a failed session to be re-established before
+	// failing the IOs queued on it
+	ReplacementTimeout = "node.session.timeo.replacement_timeout"
+	// CmdsMax is the node setting which controls the maximum number of
+	// commands queued on a session
+	CmdsMax = "node.session.cmds_max"
+	// QueueDepth is the node setting which controls the maximum number of
+	// commands queued on a LUN
+	QueueDepth = "node.session.queue_depth"
+	// NoopOutInterval is the node setting which controls the interval at
+	// which nop-out pings are sent to detect a dead connection
+	NoopOutInterval = "node.conn[0].timeo.noop_out_interval"
+)
+
+// sessionParamRange is the range of values accepted by open-iscsi
+// for a node setting
+type sessionParamRange struct {
+	min, max   int
+	powerOfTwo bool
+}
+
+var sessionParamRanges = map[string]sessionParamRange{
+	ReplacementTimeout: {min: 0, max: 86400},
+	CmdsMax:            {min: 2, max: 2048, powerOfTwo: true},
+	QueueDepth:         {min: 1, max: 1024},
+	NoopOutInterval:    {min: 0, max: 3600},
+}
+
+// ValidateSessionParam verifies that the given value can be applied to
+// the given iSCSI node setting
+func ValidateSessionParam(key, value string) error {
+	r, ok := sessionParamRanges[key]
+	if !ok {
+		return fmt.Errorf("unsupported iSCSI node setting %s", key)
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("value %q of %s is not an integer", value, key)
+	}
+	if v < r.min || v > r.max {
+		return fmt.Errorf("value %d of %s is not in range [%d, %d]", v, key, r.min, r.max)
+	}
+	if r.powerOfTwo && v&(v-1) != 0 {
+		return fmt.Errorf("value %d of %s is not a power of 2", v, key)
+	}
+	return nil
+}
+
+// updateISCSISessionParams applies the tunables of the disk to the node
+// record of the given portal, these take effect on the next login
+func updateISCSISessionParams(b iscsiDiskMounter, tp string) error {
+	keys := make([]string, 0, len(b.sessionParams))
+	for k := range b.sessionParams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := b.sessionParams[k]
+		out, err := b.exec.Command(
+			"iscsiadm", "-m", "node",
+			"-p", tp, "-T", b.Iqn,
+			"-I", b.Iface, "-o", "update",
+			"-n", k, "-v", v,
+		).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf(
+				"iscsi: failed to update node setting %q with value %q error: %v",
+				k, v, string(out),
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"testing"
+)
+
+func TestValidateSessionParam(t *testing.T) {
+	tests := map[string]struct {
+		key     string
+		value   string
+		isValid bool
+	}{
+		"replacement timeout":          {ReplacementTimeout, "120", true},
+		"zero replacement timeout":     {ReplacementTimeout, "0", true},
+		"negative replacement timeout": {ReplacementTimeout, "-1", false},
+		"non integer":                  {ReplacementTimeout, "2m", false},
+		"cmds max":                     {CmdsMax, "128", true},
+		"cmds max not power of 2":      {CmdsMax, "100", false},
+		"cmds max out of range":        {CmdsMax, "4096", false},
+		"queue depth":                  {QueueDepth, "32", true},
+		"zero queue depth":             {QueueDepth, "0", false},
+		"noop out interval":            {NoopOutInterval, "5", true},
+		"unsupported setting":          {"node.session.auth.password", "5", false},
+	}
+	for name, test := range tests {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			err := ValidateSessionParam(test.key, test.value)
+			if test.isValid && err != nil {
+				t.Fatalf("expected %s=%s to be valid, got: %v", test.key, test.value, err)
+			}
+			if !test.isValid && err == nil {
+				t.Fatalf("expected %s=%s to be invalid", test.key, test.value)
+			}
+		})
+	}
+}
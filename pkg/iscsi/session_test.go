@@ -0,0 +1,106 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"testing"
+)
+
+const fakeSessionOutput = `iSCSI Transport Class version 2.0-870
+version 2.1.5
+Target: iqn.2016-09.com.openebs.cstor:pvc-1 (non-flash)
+	Current Portal: 10.0.0.5:3260,1
+	Persistent Portal: 10.0.0.5:3260,1
+		**********
+		Interface:
+		**********
+		Iface Name: default
+		Iface Transport: tcp
+		Iface Initiatorname: iqn.1993-08.org.debian:01:abc
+		Iface IPaddress: 10.0.1.2
+		Iface HWaddress: <empty>
+		Iface Netdev: <empty>
+		SID: 1
+		iSCSI Connection State: LOGGED IN
+		iSCSI Session State: LOGGED_IN
+		Internal iscsid Session State: NO CHANGE
+		************************
+		Attached SCSI devices:
+		************************
+		Host Number: 2	State: running
+		scsi2 Channel 00 Id 0 Lun: 0
+			Attached scsi disk sdb		State: running
+Target: iqn.2016-09.com.openebs.cstor:pvc-2 (non-flash)
+	Current Portal: 10.0.0.6:3260,1
+	Persistent Portal: 10.0.0.9:3260,1
+		**********
+		Interface:
+		**********
+		Iface Name: default
+		Iface Transport: tcp
+		SID: 2
+		iSCSI Connection State: TRANSPORT WAIT
+		iSCSI Session State: FAILED
+		Internal iscsid Session State: REOPEN
+		************************
+		Attached SCSI devices:
+		************************
+		Host Number: 3	State: running
+		scsi3 Channel 00 Id 0 Lun: 0
+			Attached scsi disk sdc		State: transport-offline
+`
+
+func TestParseSessions(t *testing.T) {
+	sessions := parseSessions(fakeSessionOutput)
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions got %d: %+v", len(sessions), sessions)
+	}
+
+	healthy := sessions[0]
+	if healthy.TargetIQN != "iqn.2016-09.com.openebs.cstor:pvc-1" ||
+		healthy.CurrentPortal != "10.0.0.5:3260" ||
+		healthy.SID != "1" ||
+		healthy.IfaceName != "default" ||
+		healthy.IfaceIPAddress != "10.0.1.2" {
+		t.Errorf("unexpected session details: %+v", healthy)
+	}
+	if !healthy.IsLoggedIn() || healthy.IsFailed() {
+		t.Errorf("expected session %s to be logged in: %+v", healthy.SID, healthy)
+	}
+	if len(healthy.Devices) != 1 || healthy.Devices[0].Name != "sdb" ||
+		len(healthy.OfflineDevices()) != 0 {
+		t.Errorf("unexpected devices for session %s: %+v", healthy.SID, healthy.Devices)
+	}
+
+	failed := sessions[1]
+	if failed.PersistentPortal != "10.0.0.9:3260" || failed.CurrentPortal != "10.0.0.6:3260" {
+		t.Errorf("unexpected portals for session %s: %+v", failed.SID, failed)
+	}
+	if failed.IsLoggedIn() || !failed.IsFailed() {
+		t.Errorf("expected session %s to be failed: %+v", failed.SID, failed)
+	}
+	offline := failed.OfflineDevices()
+	if len(offline) != 1 || offline[0].State != "transport-offline" {
+		t.Errorf("expected offline device for session %s got: %+v", failed.SID, offline)
+	}
+}
+
+func TestParseSessionsEmpty(t *testing.T) {
+	if sessions := parseSessions(""); len(sessions) != 0 {
+		t.Errorf("expected no sessions got %+v", sessions)
+	}
+}
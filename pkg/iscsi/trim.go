@@ -0,0 +1,62 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	utilexec "k8s.io/utils/exec"
+	"k8s.io/utils/mount"
+)
+
+// trimmedBytesRegex extracts the byte count from the verbose output of
+// fstrim, e.g. "/mnt: 1.2 GiB (1288490188 bytes) trimmed"
+var trimmedBytesRegex = regexp.MustCompile(`\((\d+) bytes\) trimmed`)
+
+// Trim discards the unused blocks of the filesystem mounted at the given
+// path so that the thin provisioned volume can release them, it returns
+// the number of bytes discarded. The path has to be a mount point so that
+// the filesystem it would otherwise be part of is never trimmed. fstrim is
+// killed if the context is cancelled.
+func Trim(ctx context.Context, mountPath string) (int64, error) {
+	notMnt, err := mount.New("").IsLikelyNotMountPoint(mountPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to verify mount of %s: %v", mountPath, err)
+	}
+	if notMnt {
+		return 0, fmt.Errorf("%s is not mounted", mountPath)
+	}
+	out, err := utilexec.New().CommandContext(ctx, "fstrim", "-v", mountPath).CombinedOutput()
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("fstrim of %s failed: %s (%v)", mountPath, string(out), err)
+	}
+	return parseTrimmedBytes(string(out))
+}
+
+func parseTrimmedBytes(output string) (int64, error) {
+	match := trimmedBytesRegex.FindStringSubmatch(output)
+	if match == nil {
+		return 0, fmt.Errorf("unexpected fstrim output: %q", output)
+	}
+	return strconv.ParseInt(match[1], 10, 64)
+}
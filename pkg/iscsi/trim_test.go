@@ -0,0 +1,39 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iscsi
+
+import "testing"
+
+func TestParseTrimmedBytes(t *testing.T) {
+	tests := map[string]struct {
+		output  string
+		want    int64
+		wantErr bool
+	}{
+		"trimmed":    {output: "/var/lib/kubelet/staging: 1.2 GiB (1288490188 bytes) trimmed\n", want: 1288490188},
+		"nothing":    {output: "/mnt: 0 B (0 bytes) trimmed", want: 0},
+		"on device":  {output: "/mnt: 4 KiB (4096 bytes) trimmed on /dev/sdb\n", want: 4096},
+		"unexpected": {output: "fstrim: /mnt: the discard operation is not supported", wantErr: true},
+	}
+	for name, test := range tests {
+		got, err := parseTrimmedBytes(test.output)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("%s: parseTrimmedBytes(%q) = %d, %v, want %d, error %v",
+				name, test.output, got, err, test.want, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package journal keeps the attach state of the volumes staged on a node
+// on the local disk, so that the node can unstage and cleanup volumes when
+// their CVAs can't be fetched from the API server.
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	recordSuffix = ".json"
+	tmpSuffix    = ".tmp"
+)
+
+// Record is the attach state of a volume staged on the node
+type Record struct {
+	// VolumeID is the name of the volume
+	VolumeID string `json:"volumeID"`
+	// AttachmentName is the name of the CVA of the volume on the node
+	AttachmentName string `json:"attachmentName"`
+	IQN            string `json:"iqn"`
+	Portal         string `json:"portal"`
+	Lun            string `json:"lun"`
+	Iface          string `json:"iface"`
+	DevicePath     string `json:"devicePath"`
+	StagingPath    string `json:"stagingPath"`
+	FSType         string `json:"fsType,omitempty"`
+	// LUKSDevice is the name of the LUKS device of an encrypted volume
+	LUKSDevice string `json:"luksDevice,omitempty"`
+	// Unstaged is set once the volume has been unmounted and logged out
+	// while its CVA couldn't be updated, the CVA is cleaned up when the
+	// journal is next reconciled
+	Unstaged  bool      `json:"unstaged,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Journal stores one record per volume as a JSON file in its directory.
+// Records are written to a temporary file which is synced and renamed over
+// the previous record, a crash leaves either the old or the new record.
+//
+// A nil Journal is valid and does not record anything.
+type Journal struct {
+	dir string
+}
+
+// New returns the journal stored in the given directory, creating the
+// directory if required
+func New(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory %s: %v", dir, err)
+	}
+	return &Journal{dir: dir}, nil
+}
+
+func (j *Journal) path(volumeID string) string {
+	return filepath.Join(j.dir, volumeID+recordSuffix)
+}
+
+// Put records the attach state of the volume, replacing the previous record
+func (j *Journal) Put(rec *Record) error {
+	if j == nil {
+		return nil
+	}
+	if rec.VolumeID == "" {
+		return fmt.Errorf("journal record is missing the volume ID")
+	}
+	rec.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal record of %s: %v", rec.VolumeID, err)
+	}
+
+	file := j.path(rec.VolumeID)
+	tmp := file + tmpSuffix
+	if err := writeSync(tmp, data); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write journal record of %s: %v", rec.VolumeID, err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to commit journal record of %s: %v", rec.VolumeID, err)
+	}
+	return j.syncDir()
+}
+
+// Get returns the record of the volume, or nil if the volume has no record
+func (j *Journal) Get(volumeID string) (*Record, error) {
+	if j == nil {
+		return nil, nil
+	}
+	return readRecord(j.path(volumeID))
+}
+
+// Delete removes the record of the volume
+func (j *Journal) Delete(volumeID string) error {
+	if j == nil {
+		return nil
+	}
+	if err := os.Remove(j.path(volumeID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete journal record of %s: %v", volumeID, err)
+	}
+	return j.syncDir()
+}
+
+// List returns all the records of the journal. Temporary files left behind
+// by a crash in the middle of a Put are removed.
+func (j *Journal) List() ([]*Record, error) {
+	if j == nil {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list journal records: %v", err)
+	}
+	var records []*Record
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, tmpSuffix) {
+			os.Remove(filepath.Join(j.dir, name))
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, recordSuffix) {
+			continue
+		}
+		rec, err := readRecord(filepath.Join(j.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+func readRecord(file string) (*Record, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read journal record %s: %v", file, err)
+	}
+	rec := &Record{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("failed to decode journal record %s: %v", file, err)
+	}
+	return rec, nil
+}
+
+func writeSync(file string, data []byte) error {
+	fp, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(data); err != nil {
+		fp.Close()
+		return err
+	}
+	if err := fp.Sync(); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// syncDir persists the renames and removals of the records
+func (j *Journal) syncDir() error {
+	dir, err := os.Open(j.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,90 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	j, err := New(filepath.Join(t.TempDir(), "state"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	rec := &Record{
+		VolumeID:    "pvc-1",
+		IQN:         "iqn.2016-09.com.openebs.cstor:pvc-1",
+		Portal:      "10.0.0.1:3260",
+		Lun:         "0",
+		Iface:       "default",
+		StagingPath: "/var/lib/kubelet/staging/pvc-1",
+		FSType:      "ext4",
+	}
+	if err := j.Put(rec); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	rec.FSType = "xfs"
+	if err := j.Put(rec); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	got, err := j.Get("pvc-1")
+	if err != nil || got == nil {
+		t.Fatalf("Get() = %v, %v", got, err)
+	}
+	if got.FSType != "xfs" || got.Portal != rec.Portal {
+		t.Errorf("Get() = %+v, want %+v", got, rec)
+	}
+
+	// a temporary file left behind by a crash is not a record
+	if err := os.WriteFile(j.path("pvc-2")+tmpSuffix, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	records, err := j.List()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("List() = %v, %v, want 1 record", records, err)
+	}
+	if _, err := os.Stat(j.path("pvc-2") + tmpSuffix); !os.IsNotExist(err) {
+		t.Errorf("temporary file was not removed: %v", err)
+	}
+
+	if err := j.Delete("pvc-1"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if got, err := j.Get("pvc-1"); got != nil || err != nil {
+		t.Errorf("Get() after Delete() = %v, %v", got, err)
+	}
+	if err := j.Delete("pvc-1"); err != nil {
+		t.Errorf("Delete() of a missing record failed: %v", err)
+	}
+}
+
+func TestNilJournal(t *testing.T) {
+	var j *Journal
+	if err := j.Put(&Record{VolumeID: "pvc-1"}); err != nil {
+		t.Errorf("Put() failed: %v", err)
+	}
+	if rec, err := j.Get("pvc-1"); rec != nil || err != nil {
+		t.Errorf("Get() = %v, %v", rec, err)
+	}
+	if records, err := j.List(); records != nil || err != nil {
+		t.Errorf("List() = %v, %v", records, err)
+	}
+}
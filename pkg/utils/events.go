@@ -0,0 +1,80 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"sync"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	openebsscheme "github.com/openebs/api/v3/pkg/client/clientset/versioned/scheme"
+	client "github.com/openebs/cstor-csi/pkg/kubernetes/client"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// eventComponent is the source component of the events raised by the
+	// node plugin
+	eventComponent = "cstor-csi-node"
+)
+
+var (
+	recorder     record.EventRecorder
+	recorderOnce sync.Once
+)
+
+// getEventRecorder returns the event recorder of the node plugin, the
+// recorder is initialised only once on first use
+func getEventRecorder() record.EventRecorder {
+	recorderOnce.Do(func() {
+		cs, err := client.New().Clientset()
+		if err != nil {
+			logrus.Errorf("failed to initialise event recorder: %v", err)
+			return
+		}
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+			Interface: cs.CoreV1().Events(""),
+		})
+		recorder = broadcaster.NewRecorder(
+			openebsscheme.Scheme,
+			corev1.EventSource{Component: eventComponent, Host: NodeIDENV},
+		)
+	})
+	return recorder
+}
+
+// RecordCVAEvent raises a kubernetes event against the given
+// CStorVolumeAttachment. Failure to raise an event is only logged since
+// events are informational.
+func RecordCVAEvent(
+	vol *apis.CStorVolumeAttachment,
+	eventType, reason, messageFmt string,
+	args ...interface{},
+) {
+	r := getEventRecorder()
+	if r == nil || vol == nil {
+		return
+	}
+	// Kind and APIVersion are not populated on objects returned by the
+	// typed clientset, these are required to build the event reference
+	obj := vol.DeepCopy()
+	obj.SetGroupVersionKind(apis.SchemeGroupVersion.WithKind("CStorVolumeAttachment"))
+	r.Eventf(obj, eventType, reason, messageFmt, args...)
+}
@@ -15,18 +15,24 @@
 package utils
 
 import (
+	"context"
+	"strconv"
 	"strings"
 	"time"
 
 	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	clientset "github.com/openebs/api/v3/pkg/client/clientset/versioned"
+	informers "github.com/openebs/api/v3/pkg/client/informers/externalversions"
 	errors "github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
 
 	csv "github.com/openebs/cstor-csi/pkg/cstor/volume"
 	csivolume "github.com/openebs/cstor-csi/pkg/cstor/volumeattachment"
+	client "github.com/openebs/cstor-csi/pkg/kubernetes/client"
 	node "github.com/openebs/cstor-csi/pkg/kubernetes/node"
 	pv "github.com/openebs/cstor-csi/pkg/kubernetes/persistentvolume"
 )
@@ -40,6 +46,73 @@ const (
 
 	// VOLNAME is the name of the provisioned volume
 	VOLNAME = "Volname"
+
+	// ISCSISessionStateAnnotation records the last observed state of the
+	// iSCSI session of the volume on the node owning the CVA
+	ISCSISessionStateAnnotation = "openebs.io/iscsi-session-state"
+
+	// ISCSISessionRecoveryAnnotation records the outcome of the last iSCSI
+	// session recovery attempted on the node owning the CVA
+	ISCSISessionRecoveryAnnotation = "openebs.io/iscsi-session-recovery"
+
+	// ISCSISessionParamsAnnotation holds the iscsiadm node settings, encoded
+	// as JSON, which are applied every time the node logs in to the volume
+	ISCSISessionParamsAnnotation = "openebs.io/iscsi-session-params"
+
+	// ISCSIHostInterfaceAnnotation holds the host network interface to which
+	// the iSCSI logins of the volume are bound on the node owning the CVA
+	ISCSIHostInterfaceAnnotation = "openebs.io/iscsi-host-interface"
+
+	// PublishTargetsAnnotation holds the paths, encoded as JSON, at which
+	// the volume is published on the node along with their mount options
+	PublishTargetsAnnotation = "openebs.io/publish-targets"
+
+	// BlockDeviceRecoveryAnnotation records the outcome of the last recovery
+	// of the device published for a raw block volume
+	BlockDeviceRecoveryAnnotation = "openebs.io/block-device-recovery"
+
+	// LUKSDeviceAnnotation holds the name of the LUKS device opened on top
+	// of the iSCSI disk of an encrypted volume on the node owning the CVA
+	LUKSDeviceAnnotation = "openebs.io/luks-device"
+
+	// MkfsOptionsAnnotation holds the mkfs options of the volume, they are
+	// only applied when the device of the volume is first formatted
+	MkfsOptionsAnnotation = "openebs.io/mkfs-options"
+
+	// FsckPolicyAnnotation holds the fsck policy of the volume, it is
+	// applied whenever the staging path is mounted on the node
+	FsckPolicyAnnotation = "openebs.io/fsck-policy"
+
+	// FsckResultAnnotation holds the outcome and output of the last check
+	// of the filesystem of the volume before it was staged on the node
+	FsckResultAnnotation = "openebs.io/fsck-result"
+
+	// TrimScheduleAnnotation holds the interval at which the filesystem of
+	// the volume is trimmed
+	TrimScheduleAnnotation = "openebs.io/trim-schedule"
+
+	// TrimRequestedAnnotation can be set on the CVA to have the volume
+	// trimmed right away, it is removed once the trim is done
+	TrimRequestedAnnotation = "openebs.io/trim-requested"
+
+	// LastTrimAnnotation holds the time of the last trim of the volume
+	LastTrimAnnotation = "openebs.io/last-trim"
+
+	// LastTrimBytesAnnotation holds the number of bytes discarded by the
+	// last successful trim of the volume
+	LastTrimBytesAnnotation = "openebs.io/last-trim-bytes"
+
+	// LastTrimErrorAnnotation holds the error of the last trim of the
+	// volume if it failed
+	LastTrimErrorAnnotation = "openebs.io/last-trim-error"
+
+	// VolumeMountGroupAnnotation holds the group applied to the root of the
+	// filesystem of the volume while it is staged on the node
+	VolumeMountGroupAnnotation = "openebs.io/volume-mount-group"
+
+	// MultiNodeReaderOnly is the access mode recorded on the CVAs of the
+	// volumes attached read-only to several nodes
+	MultiNodeReaderOnly = "MULTI_NODE_READER_ONLY"
 )
 
 var (
@@ -58,6 +131,23 @@ func FetchPVDetails(name string) (*corev1.PersistentVolume, error) {
 	return pv.NewKubeClient().Get(name, metav1.GetOptions{})
 }
 
+// PodExists returns true if the pod with the given namespace, name and UID
+// exists, a pod recreated with the same name is another pod
+func PodExists(namespace, name, uid string) (bool, error) {
+	cs, err := client.New().Clientset()
+	if err != nil {
+		return false, err
+	}
+	pod, err := cs.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return string(pod.UID) == uid, nil
+}
+
 // getVolStatus fetches the current VolumeStatus which specifies if the volume
 // is ready to serve IOs
 func getVolStatus(volumeID string) (string, error) {
@@ -82,6 +172,24 @@ func getVolStatus(volumeID string) (string, error) {
 	return string(volumeList.Items[0].Status.Phase), nil
 }
 
+// NewCStorInformerFactory returns a shared informer factory for the cstor
+// resources present in the openebs namespace
+func NewCStorInformerFactory(
+	resync time.Duration,
+	opts ...informers.SharedInformerOption,
+) (informers.SharedInformerFactory, error) {
+	config, err := client.GetConfig(client.New())
+	if err != nil {
+		return nil, err
+	}
+	cs, err := clientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, informers.WithNamespace(OpenEBSNamespace))
+	return informers.NewSharedInformerFactoryWithOptions(cs, resync, opts...), nil
+}
+
 // GetVolListForNode fetches the current Published Volume list
 func GetVolListForNode() (*apis.CStorVolumeAttachmentList, error) {
 	listOptions := metav1.ListOptions{
@@ -152,11 +260,76 @@ func UpdateCStorVolumeAttachmentCR(csivol *apis.CStorVolumeAttachment) (*apis.CS
 		WithNamespace(OpenEBSNamespace).Update(csivol)
 }
 
+// UpdateCStorVolumeAttachmentAnnotations merges the given annotations into
+// the CStorVolumeAttachment CR, the update is retried on conflicts since the
+// CR is also updated by the CSI RPCs
+func UpdateCStorVolumeAttachmentAnnotations(csivolName string, annotations map[string]string) error {
+	return updateCStorVolumeAttachment(csivolName, func(csivol *apis.CStorVolumeAttachment) {
+		if csivol.Annotations == nil {
+			csivol.Annotations = map[string]string{}
+		}
+		for key, value := range annotations {
+			csivol.Annotations[key] = value
+		}
+	})
+}
+
+// UpdateCStorVolumeAttachmentStatus sets the status of the
+// CStorVolumeAttachment CR and merges the given annotations into it
+func UpdateCStorVolumeAttachmentStatus(
+	csivolName string,
+	status apis.CStorVolumeAttachmentStatus,
+	annotations map[string]string,
+) error {
+	return updateCStorVolumeAttachment(csivolName, func(csivol *apis.CStorVolumeAttachment) {
+		csivol.Status = status
+		if csivol.Annotations == nil {
+			csivol.Annotations = map[string]string{}
+		}
+		for key, value := range annotations {
+			csivol.Annotations[key] = value
+		}
+	})
+}
+
+// RecordVolumeTrim records the outcome of a trim of the volume on the
+// CStorVolumeAttachment CR and clears the request for it if any
+func RecordVolumeTrim(csivolName string, at time.Time, trimmed int64, trimErr error) error {
+	return updateCStorVolumeAttachment(csivolName, func(csivol *apis.CStorVolumeAttachment) {
+		if csivol.Annotations == nil {
+			csivol.Annotations = map[string]string{}
+		}
+		csivol.Annotations[LastTrimAnnotation] = at.UTC().Format(time.RFC3339)
+		if trimErr != nil {
+			csivol.Annotations[LastTrimErrorAnnotation] = trimErr.Error()
+		} else {
+			csivol.Annotations[LastTrimBytesAnnotation] = strconv.FormatInt(trimmed, 10)
+			delete(csivol.Annotations, LastTrimErrorAnnotation)
+		}
+		delete(csivol.Annotations, TrimRequestedAnnotation)
+	})
+}
+
+// updateCStorVolumeAttachment applies the given mutation on the latest copy
+// of the CStorVolumeAttachment CR, the update is retried on conflicts
+func updateCStorVolumeAttachment(csivolName string, mutate func(*apis.CStorVolumeAttachment)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		csivol, err := GetCStorVolumeAttachment(csivolName)
+		if err != nil {
+			return err
+		}
+		mutate(csivol)
+		_, err = UpdateCStorVolumeAttachmentCR(csivol)
+		return err
+	})
+}
+
 // TODO Explain when a create of csi volume happens & when it
 // gets deleted or replaced or updated
 
-// DeleteOldCStorVolumeAttachmentCRs removes the CStorVolumeAttachmentCR for the specified path
-func DeleteOldCStorVolumeAttachmentCRs(volumeID, nodeID string) error {
+// DeleteOldCStorVolumeAttachmentCRs removes the CStorVolumeAttachmentCR for the specified path,
+// the read-only attachments of the other nodes are retained if keepReadOnly is set
+func DeleteOldCStorVolumeAttachmentCRs(volumeID, nodeID string, keepReadOnly bool) error {
 	// nodeCVA contains the name of the cStor volume attachment for the current node
 	var nodeCVA string
 
@@ -166,6 +339,11 @@ func DeleteOldCStorVolumeAttachmentCRs(volumeID, nodeID string) error {
 	}
 
 	for _, csivol := range csivols.Items {
+		// Read-only attachments of the other nodes are shared with the
+		// read-only attachment being created for this node
+		if keepReadOnly && csivol.Labels[NODEID] != nodeID && IsReadOnlyAttachment(&csivol) {
+			continue
+		}
 		logrus.Infof("Marking cva %s for deletion", csivol.Name)
 		err = csivolume.NewKubeclient().
 			WithNamespace(OpenEBSNamespace).Delete(csivol.Name)
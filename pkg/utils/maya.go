@@ -59,6 +59,13 @@ const (
 	// IscsiInterface set
 	DefaultIscsiInterface = "default"
 
+	// EphemeralPodAnnotation holds the namespace/name of the pod whose
+	// ephemeral volume the CVC backs
+	EphemeralPodAnnotation = "openebs.io/ephemeral-pod"
+	// EphemeralPodUIDAnnotation holds the UID of the pod whose ephemeral
+	// volume the CVC backs, the CVC is deleted once the pod is gone
+	EphemeralPodUIDAnnotation = "openebs.io/ephemeral-pod-uid"
+
 	// volumeCreatedThrough used to identify through which PVC is created
 	// NOTE: This annoation will be available on PVC only if velero-plugin
 	// creates as PVC as a part of restore request
@@ -66,7 +73,8 @@ const (
 )
 
 // ProvisionVolume creates a CstorVolumeConfig(cvc) CR,
-// watcher for cvc is present in cvc-operator
+// watcher for cvc is present in cvc-operator. The extra
+// annotations are set on the cvc as is.
 func ProvisionVolume(
 	size int64,
 	volName,
@@ -77,6 +85,7 @@ func ProvisionVolume(
 	policyName,
 	pvcName,
 	pvcNamespace string,
+	extraAnnotations map[string]string,
 ) error {
 
 	var pvcObj *corev1.PersistentVolumeClaim
@@ -87,6 +96,9 @@ func ProvisionVolume(
 		OpenebsVolumePolicy: policyName,
 		OpenebsPVC:          pvcName,
 	}
+	for key, value := range extraAnnotations {
+		annotations[key] = value
+	}
 
 	if pvcName != "" {
 		pvcObj, err = pvc.NewKubeClient().WithNamespace(pvcNamespace).Get(pvcName, metav1.GetOptions{})
@@ -142,6 +154,13 @@ func GetVolume(volumeID string) (*cstorapis.CStorVolumeConfig, error) {
 		Get(volumeID, metav1.GetOptions{})
 }
 
+// ListVolumes lists the CstorVolumeConfig(cvc) CRs
+func ListVolumes() (*cstorapis.CStorVolumeConfigList, error) {
+	return cvc.NewKubeclient().
+		WithNamespace(OpenEBSNamespace).
+		List(metav1.ListOptions{})
+}
+
 // IsSourceAvailable returns true if the source volume is available
 func IsSourceAvailable(snapshotID string) (bool, error) {
 	srcVolName, _, err := GetVolumeSourceDetails(snapshotID)
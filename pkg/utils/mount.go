@@ -19,6 +19,7 @@ package utils
 import (
 	"os"
 
+	iscsiutils "github.com/openebs/cstor-csi/pkg/iscsi"
 	utilexec "k8s.io/utils/exec"
 	"k8s.io/utils/mount"
 	utilpath "k8s.io/utils/path"
@@ -37,6 +38,37 @@ func NewNodeMounter() *NodeMounter {
 	}
 }
 
+// FormatAndMount formats the device with the mkfs arguments of the
+// filesystem handler if it is blank and mounts it. Devices which are
+// mounted read-only are never formatted.
+func (m *NodeMounter) FormatAndMount(source, target, fstype string, options []string) error {
+	return m.FormatAndMountWithMkfsOptions(source, target, fstype, nil, options)
+}
+
+// FormatAndMountWithMkfsOptions is FormatAndMount with extra mkfs options,
+// which only take effect if the device is blank
+func (m *NodeMounter) FormatAndMountWithMkfsOptions(source, target, fstype string, mkfsOptions, options []string) error {
+	if fstype == "" {
+		fstype = "ext4"
+	}
+	readOnly := false
+	for _, option := range options {
+		readOnly = readOnly || option == "ro"
+	}
+	if !readOnly {
+		sig, err := iscsiutils.ProbeDevice(source)
+		if err != nil {
+			return err
+		}
+		if sig.Blank() {
+			if err := iscsiutils.FormatDevice(source, fstype, mkfsOptions); err != nil {
+				return err
+			}
+		}
+	}
+	return m.SafeFormatAndMount.FormatAndMount(source, target, fstype, options)
+}
+
 func (m *NodeMounter) GetDeviceName(mountPath string) (string, int, error) {
 	return mount.GetDeviceNameFromMount(m, mountPath)
 }
@@ -0,0 +1,158 @@
+/*
+ Copyright © 2023 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/cstor-csi/pkg/utils"
+)
+
+func newCVA(targetPath string, readOnly bool, annotations map[string]string) *apis.CStorVolumeAttachment {
+	vol := &apis.CStorVolumeAttachment{}
+	vol.Name = "pvc-1-node-1"
+	vol.Annotations = annotations
+	vol.Spec.Volume.TargetPath = targetPath
+	vol.Spec.Volume.ReadOnly = readOnly
+	return vol
+}
+
+func Test_PublishTargets(t *testing.T) {
+	testcases := []struct {
+		name    string
+		vol     *apis.CStorVolumeAttachment
+		targets []utils.PublishTarget
+	}{
+		{
+			name: "not published",
+			vol:  newCVA("", false, nil),
+		},
+		{
+			name:    "single target path",
+			vol:     newCVA("/pods/a/mount", true, nil),
+			targets: []utils.PublishTarget{{Path: "/pods/a/mount", ReadOnly: true}},
+		},
+		{
+			name: "recorded targets",
+			vol: newCVA("/pods/a/mount", false, map[string]string{
+				utils.PublishTargetsAnnotation: `[{"path":"/pods/a/mount"},{"path":"/pods/b/mount","readOnly":true,"mountFlags":["noexec"]}]`,
+			}),
+			targets: []utils.PublishTarget{
+				{Path: "/pods/a/mount"},
+				{Path: "/pods/b/mount", ReadOnly: true, MountFlags: []string{"noexec"}},
+			},
+		},
+		{
+			name: "invalid recorded targets",
+			vol: newCVA("/pods/a/mount", false, map[string]string{
+				utils.PublishTargetsAnnotation: `{"path"`,
+			}),
+			targets: []utils.PublishTarget{{Path: "/pods/a/mount"}},
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := utils.PublishTargets(tt.vol); !reflect.DeepEqual(got, tt.targets) {
+				t.Errorf("PublishTargets() = %+v, want %+v", got, tt.targets)
+			}
+		})
+	}
+}
+
+func Test_AddRemovePublishTarget(t *testing.T) {
+	a := utils.PublishTarget{Path: "/pods/a/mount"}
+	b := utils.PublishTarget{Path: "/pods/b/mount", ReadOnly: true}
+	testcases := []struct {
+		name       string
+		vol        *apis.CStorVolumeAttachment
+		add        *utils.PublishTarget
+		remove     string
+		targets    []utils.PublishTarget
+		targetPath string
+		readOnly   bool
+	}{
+		{
+			name:       "add first target",
+			vol:        newCVA("", false, nil),
+			add:        &b,
+			targets:    []utils.PublishTarget{b},
+			targetPath: b.Path,
+			readOnly:   true,
+		},
+		{
+			name:       "add second target",
+			vol:        newCVA(a.Path, false, nil),
+			add:        &b,
+			targets:    []utils.PublishTarget{a, b},
+			targetPath: a.Path,
+		},
+		{
+			name: "replace target of same path",
+			vol:  newCVA(a.Path, false, nil),
+			add: &utils.PublishTarget{
+				Path: a.Path, MountFlags: []string{"noexec"},
+			},
+			targets:    []utils.PublishTarget{{Path: a.Path, MountFlags: []string{"noexec"}}},
+			targetPath: a.Path,
+		},
+		{
+			name: "remove first target",
+			vol: newCVA(a.Path, false, map[string]string{
+				utils.PublishTargetsAnnotation: `[{"path":"/pods/a/mount"},{"path":"/pods/b/mount","readOnly":true}]`,
+			}),
+			remove:     a.Path,
+			targets:    []utils.PublishTarget{b},
+			targetPath: b.Path,
+			readOnly:   true,
+		},
+		{
+			name:   "remove last target",
+			vol:    newCVA(b.Path, true, nil),
+			remove: b.Path,
+		},
+		{
+			name:       "remove unknown target",
+			vol:        newCVA(a.Path, false, nil),
+			remove:     b.Path,
+			targets:    []utils.PublishTarget{a},
+			targetPath: a.Path,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.add != nil {
+				utils.AddPublishTarget(tt.vol, *tt.add)
+			} else {
+				utils.RemovePublishTarget(tt.vol, tt.remove)
+			}
+			if got := utils.PublishTargets(tt.vol); !reflect.DeepEqual(got, tt.targets) {
+				t.Errorf("PublishTargets() = %+v, want %+v", got, tt.targets)
+			}
+			if tt.vol.Spec.Volume.TargetPath != tt.targetPath || tt.vol.Spec.Volume.ReadOnly != tt.readOnly {
+				t.Errorf("target path = %q read only %t, want %q read only %t",
+					tt.vol.Spec.Volume.TargetPath, tt.vol.Spec.Volume.ReadOnly, tt.targetPath, tt.readOnly)
+			}
+			if len(tt.targets) == 0 {
+				if _, ok := tt.vol.Annotations[utils.PublishTargetsAnnotation]; ok {
+					t.Errorf("expected %s to be removed", utils.PublishTargetsAnnotation)
+				}
+			}
+		})
+	}
+}
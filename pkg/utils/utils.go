@@ -16,11 +16,11 @@ limitations under the License.
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"os"
 	"strings"
-	"sync"
 	"time"
 
 	"golang.org/x/net/context"
@@ -64,17 +64,6 @@ var (
 
 	// NodeIDENV is the NodeID of the node on which the pod is present
 	NodeIDENV string
-
-	// TransitionVolList contains the list of volumes under transition
-	// This list is protected by TransitionVolListLock
-	TransitionVolList map[string]apis.CStorVolumeAttachmentStatus
-
-	// TransitionVolListLock is required to protect the above Volumes list
-	TransitionVolListLock sync.RWMutex
-
-	// ReqMountList contains the list of volumes which are required
-	// to be remounted. This list is secured by ReqMountListLock
-	ReqMountList map[string]apis.CStorVolumeAttachmentStatus
 )
 
 const (
@@ -83,19 +72,20 @@ const (
 )
 
 func init() {
-
 	OpenEBSNamespace = os.Getenv("OPENEBS_NAMESPACE")
+	NodeIDENV = os.Getenv("OPENEBS_NODE_ID")
+}
+
+// VerifyEnv returns an error if the environment variables the driver
+// depends on are not set
+func VerifyEnv() error {
 	if OpenEBSNamespace == "" {
-		logrus.Fatalf("OPENEBS_NAMESPACE environment variable not set")
+		return fmt.Errorf("OPENEBS_NAMESPACE environment variable not set")
 	}
-	NodeIDENV = os.Getenv("OPENEBS_NODE_ID")
 	if NodeIDENV == "" && os.Getenv("OPENEBS_NODE_DRIVER") != "" {
-		logrus.Fatalf("OPENEBS_NODE_ID not set")
+		return fmt.Errorf("OPENEBS_NODE_ID not set")
 	}
-
-	TransitionVolList = make(map[string]apis.CStorVolumeAttachmentStatus)
-	ReqMountList = make(map[string]apis.CStorVolumeAttachmentStatus)
-
+	return nil
 }
 
 // parseEndpoint should have a valid prefix(unix/tcp)
@@ -203,9 +193,6 @@ checkVolumeStatus:
 			volumeID,
 		)
 	} else {
-		TransitionVolListLock.Lock()
-		TransitionVolList[volumeID] = apis.CStorVolumeAttachmentStatusWaitingForVolumeToBeReady
-		TransitionVolListLock.Unlock()
 		time.Sleep(VolumeWaitTimeout * time.Second)
 		retries++
 		goto checkVolumeStatus
@@ -226,179 +213,6 @@ checkVolumeStatus:
 			fmt.Errorf("volume name %s does not exit in the volumes list", volName)
 	}
 */
-func listContains(
-	mountPath string, list []mount.MountPoint,
-) (*mount.MountPoint, bool) {
-	for _, info := range list {
-		if info.Path == mountPath {
-			mntInfo := info
-			return &mntInfo, true
-		}
-	}
-	return nil, false
-}
-
-// MonitorMounts makes sure that all the volumes present in the inmemory list
-// with the driver are mounted with the original mount options
-// This function runs a never ending loop therefore should be run as a goroutine
-// Mounted list is fetched from the OS and the state of all the volumes is
-// reverified after every 5 seconds. If the mountpoint is not present in the
-// list or if it has been remounted with a different mount option by the OS, the
-// volume is added to the ReqMountList which is removed as soon as the remount
-// operation on the volume is complete
-// For each remount operation a new goroutine is created, so that if multiple
-// volumes have lost their original state they can all be remounted in parallel
-func MonitorMounts() {
-	var (
-		err        error
-		csivolList *apis.CStorVolumeAttachmentList
-		mountList  []mount.MountPoint
-	)
-	mounter := mount.New("")
-	ticker := time.NewTicker(MonitorMountRetryTimeout * time.Second)
-	for {
-		cleanupRequired := false
-		select {
-		case <-ticker.C:
-			// Get list of mounted paths present with the node
-			TransitionVolListLock.Lock()
-			if mountList, err = mounter.List(); err != nil {
-				TransitionVolListLock.Unlock()
-				break
-			}
-			if csivolList, err = GetVolListForNode(); err != nil {
-				TransitionVolListLock.Unlock()
-				break
-			}
-			for _, vol := range csivolList.Items {
-				// ignore monitoring for volumes with deletion timestamp set
-				if vol.DeletionTimestamp != nil {
-					logrus.Infof("CVA: %v for volume: %v has been marked for cleanup activity", vol.Name, vol.Spec.Volume.Name)
-					cleanupRequired = true
-					continue
-				}
-				// ignore monitoring the mount for a block device
-				if vol.Spec.Volume.AccessType == "block" {
-					continue
-				}
-				// This check is added to avoid monitoring volume if it has not
-				// been mounted yet. Although CStorVolumeAttachment CR gets created at
-				// ControllerPublish step.
-				if (vol.Spec.Volume.StagingTargetPath == "") ||
-					(vol.Spec.Volume.TargetPath == "") {
-					continue
-				}
-				// Search the volume in the list of mounted volumes at the node
-				// retrieved above
-				stagingMountPoint, stagingPathExists := listContains(
-					vol.Spec.Volume.StagingTargetPath, mountList,
-				)
-				_, targetPathExists := listContains(
-					vol.Spec.Volume.TargetPath, mountList,
-				)
-				// If the volume is present in the list verify its state
-				// If stagingPath is in rw then TargetPath will also be in rw
-				// mode
-				if stagingPathExists && targetPathExists &&
-					verifyMountOpts(stagingMountPoint.Opts, "rw") {
-					// Continue with remaining volumes since this volume looks
-					// to be in good shape
-					continue
-				}
-				if _, ok := TransitionVolList[vol.Spec.Volume.Name]; !ok {
-					csivol := vol
-					TransitionVolList[csivol.Spec.Volume.Name] = apis.CStorVolumeAttachmentStatusRemountUnderProgress
-					ReqMountList[csivol.Spec.Volume.Name] = apis.CStorVolumeAttachmentStatusRemountUnderProgress
-					go func(csivol apis.CStorVolumeAttachment) {
-						logrus.Infof("Remounting vol: %s at %s and %s",
-							csivol.Spec.Volume.Name, csivol.Spec.Volume.StagingTargetPath,
-							csivol.Spec.Volume.TargetPath)
-						defer func() {
-							TransitionVolListLock.Lock()
-							// Remove the volume from ReqMountList once the remount operation is
-							// complete
-							delete(TransitionVolList, csivol.Spec.Volume.Name)
-							delete(ReqMountList, csivol.Spec.Volume.Name)
-							TransitionVolListLock.Unlock()
-						}()
-						if err := RemountVolume(
-							stagingPathExists, targetPathExists,
-							&csivol,
-						); err != nil {
-							logrus.Errorf(
-								"Remount failed for vol: %s : err: %v",
-								csivol.Spec.Volume.Name, err,
-							)
-						} else {
-							logrus.Infof(
-								"Remount successful for vol: %s",
-								csivol.Spec.Volume.Name,
-							)
-						}
-					}(csivol)
-				}
-			}
-			TransitionVolListLock.Unlock()
-		}
-		if cleanupRequired {
-			Cleanup()
-		}
-	}
-}
-
-// Cleanup unmounts and detaches the volumes having
-// DeletionTimestamp set and removes finalizers from the
-// corresponding CStorVolumeAttachment CRs
-func Cleanup() (err error) {
-	var (
-		csivolList *apis.CStorVolumeAttachmentList
-	)
-	// Get list of mounted paths present with the node
-	TransitionVolListLock.Lock()
-	defer TransitionVolListLock.Unlock()
-	count := 0
-	for {
-		if csivolList, err = GetVolListForNode(); err == nil {
-			break
-		}
-		time.Sleep(time.Second)
-		count++
-		if count == 5 {
-			logrus.Errorf("Failed to get cva list, err: %v", err)
-			return
-		}
-	}
-	for _, Vol := range csivolList.Items {
-		if Vol.DeletionTimestamp == nil {
-			continue
-		}
-		vol := Vol
-		TransitionVolList[vol.Spec.Volume.Name] = apis.CStorVolumeAttachmentStatusUnmountUnderProgress
-
-		logrus.Infof("Volume: %v marked as %v as part of cleanup activity", vol.Spec.Volume.Name, TransitionVolList[vol.Spec.Volume.Name])
-		// This is being run in a go routine so that if unmount and detach
-		// commands take time, the startup is not delayed
-		go func(vol *apis.CStorVolumeAttachment) {
-			logrus.Infof("Cleaning up %s from node", vol.Spec.Volume.Name)
-			if err := iscsiutils.UnmountAndDetachDisk(vol, vol.Spec.Volume.StagingTargetPath); err == nil {
-				vol.Finalizers = nil
-				logrus.Infof("Cleaning up cva %s", vol.Name)
-				if vol, err = UpdateCStorVolumeAttachmentCR(vol); err != nil {
-					logrus.Errorf(err.Error())
-				}
-			} else {
-				logrus.Errorf(err.Error())
-			}
-
-			TransitionVolListLock.Lock()
-			delete(TransitionVolList, vol.Spec.Volume.Name)
-			logrus.Infof("Volume: %v has been successfully removed from the transition volume list after cleanup: %v", vol.Spec.Volume.Name, TransitionVolList)
-			TransitionVolListLock.Unlock()
-		}(&vol)
-	}
-	return
-}
-
 // IsVolumeReachable makes a TCP connection to target
 // and checks if volume is Reachable
 func IsVolumeReachable(volumeID, targetPortal string) (bool, error) {
@@ -456,49 +270,180 @@ func WaitForVolumeReadyAndReachable(vol *apis.CStorVolumeAttachment) error {
 	return nil
 }
 
-func verifyMountOpts(opts []string, desiredOpt string) bool {
-	for _, opt := range opts {
-		if opt == desiredOpt {
+// StagingMountOptions returns the filesystem type and the mount options the
+// volume was staged with as recorded on the CVA, an empty filesystem type
+// lets mount detect it
+func StagingMountOptions(vol *apis.CStorVolumeAttachment) (string, []string) {
+	return vol.Spec.Volume.FSType, append([]string{}, vol.Spec.Volume.MountOptions...)
+}
+
+// MountDevicePath returns the device the volume is mounted from, which is
+// the LUKS device opened on top of the iSCSI disk for encrypted volumes
+func MountDevicePath(vol *apis.CStorVolumeAttachment) string {
+	if name := vol.Annotations[LUKSDeviceAnnotation]; name != "" {
+		return iscsiutils.LUKSDevicePath(name)
+	}
+	return vol.Spec.Volume.DevicePath
+}
+
+// PublishTarget is a path at which the volume is published on the node
+type PublishTarget struct {
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+	// MountFlags are the mount flags the volume was published with
+	MountFlags []string `json:"mountFlags,omitempty"`
+}
+
+// MountOptions returns the options the staging path, or the device of a
+// raw block volume, is bind mounted with at the target path
+func (t PublishTarget) MountOptions() []string {
+	options := []string{"bind"}
+	if t.ReadOnly {
+		options = append(options, "ro")
+	}
+	for _, flag := range t.MountFlags {
+		if !containsString(options, flag) {
+			options = append(options, flag)
+		}
+	}
+	return options
+}
+
+// PublishTargets returns the targets the volume is published at as recorded
+// on the CVA. CVAs recorded by earlier versions only hold a single target.
+func PublishTargets(vol *apis.CStorVolumeAttachment) []PublishTarget {
+	if data := vol.Annotations[PublishTargetsAnnotation]; data != "" {
+		var targets []PublishTarget
+		if err := json.Unmarshal([]byte(data), &targets); err == nil {
+			return targets
+		}
+		logrus.Errorf("invalid publish targets recorded on cva %s: %s", vol.Name, data)
+	}
+	if vol.Spec.Volume.TargetPath == "" {
+		return nil
+	}
+	return []PublishTarget{{
+		Path:     vol.Spec.Volume.TargetPath,
+		ReadOnly: vol.Spec.Volume.ReadOnly,
+	}}
+}
+
+// AddPublishTarget records the target on the CVA, replacing the previous
+// record of the same path if any
+func AddPublishTarget(vol *apis.CStorVolumeAttachment, target PublishTarget) {
+	targets := []PublishTarget{}
+	for _, t := range PublishTargets(vol) {
+		if t.Path != target.Path {
+			targets = append(targets, t)
+		}
+	}
+	setPublishTargets(vol, append(targets, target))
+}
+
+// RemovePublishTarget removes the target at the given path from the CVA
+func RemovePublishTarget(vol *apis.CStorVolumeAttachment, path string) {
+	targets := []PublishTarget{}
+	for _, t := range PublishTargets(vol) {
+		if t.Path != path {
+			targets = append(targets, t)
+		}
+	}
+	setPublishTargets(vol, targets)
+}
+
+// setPublishTargets records the targets on the CVA. The first target is
+// also recorded in the target path of the volume spec for the readers
+// which only know of a single target.
+func setPublishTargets(vol *apis.CStorVolumeAttachment, targets []PublishTarget) {
+	if vol.Annotations == nil {
+		vol.Annotations = map[string]string{}
+	}
+	if len(targets) == 0 {
+		delete(vol.Annotations, PublishTargetsAnnotation)
+		vol.Spec.Volume.TargetPath = ""
+		vol.Spec.Volume.ReadOnly = false
+		return
+	}
+	// PublishTarget only holds strings and bools, it always encodes
+	data, _ := json.Marshal(targets)
+	vol.Annotations[PublishTargetsAnnotation] = string(data)
+	vol.Spec.Volume.TargetPath = targets[0].Path
+	vol.Spec.Volume.ReadOnly = targets[0].ReadOnly
+}
+
+// IsReadOnlyAttachment returns true if the volume is attached to the node
+// in MULTI_NODE_READER_ONLY mode, such attachments can exist on several
+// nodes at a time
+func IsReadOnlyAttachment(vol *apis.CStorVolumeAttachment) bool {
+	return containsString(vol.Spec.Volume.AccessModes, MultiNodeReaderOnly)
+}
+
+// IsReadOnlyMount returns true if the given mount options make the mount
+// read-only
+func IsReadOnlyMount(options []string) bool {
+	return containsString(options, "ro")
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
 			return true
 		}
 	}
 	return false
 }
 
-// RemountVolume unmounts the volume if it is already mounted in an undesired
-// state and then tries to mount again. If it is not mounted the volume, first
-// the disk will be attached via iSCSI login and then it will be mounted
+// RemountVolume mounts the volume again with the mount options recorded on
+// the CVA, a read-only publish stays read-only. The staging path is mounted
+// again by remountStaging along with all the publish paths if it is set,
+// otherwise only the given publish paths are bound again. Paths which are still mounted in an undesired state, as per the
+// given mounted paths, are unmounted first.
 func RemountVolume(
-	stagingPathExists bool, targetPathExists bool,
 	vol *apis.CStorVolumeAttachment,
+	remountStaging func(*apis.CStorVolumeAttachment) error,
+	targets []PublishTarget,
+	mounted map[string]bool,
 ) error {
 	mounter := mount.New("")
-	options := []string{"rw"}
+	staging := vol.Spec.Volume.StagingTargetPath
 
-	if ready, err := IsVolumeReady(vol.Spec.Volume.Name); err != nil || !ready {
-		return fmt.Errorf("Volume %s is not ready", vol.Spec.Volume.Name)
-	}
-	if reachable, err := IsVolumeReachable(vol.Spec.Volume.Name, vol.Spec.ISCSI.TargetPortal); err != nil || !reachable {
-		return fmt.Errorf("Volume %s is not reachable", vol.Spec.Volume.Name)
+	if remountStaging != nil {
+		if ready, err := IsVolumeReady(vol.Spec.Volume.Name); err != nil || !ready {
+			return fmt.Errorf("Volume %s is not ready", vol.Spec.Volume.Name)
+		}
+		if reachable, err := IsVolumeReachable(vol.Spec.Volume.Name, vol.Spec.ISCSI.TargetPortal); err != nil || !reachable {
+			return fmt.Errorf("Volume %s is not reachable", vol.Spec.Volume.Name)
+		}
+		targets = PublishTargets(vol)
 	}
-	if stagingPathExists {
-		mounter.Unmount(vol.Spec.Volume.StagingTargetPath)
+	for _, target := range targets {
+		if mounted[target.Path] {
+			mounter.Unmount(target.Path)
+		}
 	}
-	if targetPathExists {
-		mounter.Unmount(vol.Spec.Volume.TargetPath)
+
+	if remountStaging != nil {
+		if mounted[staging] {
+			mounter.Unmount(staging)
+		}
+		// Unmount and mount operation is performed instead of just remount since
+		// the remount option didn't give the desired results
+		if err := remountStaging(vol); err != nil {
+			return err
+		}
 	}
 
-	// Unmount and mount operation is performed instead of just remount since
-	// the remount option didn't give the desired results
-	if err := mounter.Mount(vol.Spec.Volume.DevicePath,
-		vol.Spec.Volume.StagingTargetPath, "", options,
-	); err != nil {
-		return err
+	var errs []string
+	for _, target := range targets {
+		if err := mounter.Mount(staging, target.Path, "", target.MountOptions()); err != nil {
+			errs = append(errs, err.Error())
+		}
 	}
-	options = []string{"bind"}
-	err := mounter.Mount(vol.Spec.Volume.StagingTargetPath,
-		vol.Spec.Volume.TargetPath, "", options)
-	return err
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to publish volume %s: %s",
+			vol.Spec.Volume.Name, strings.Join(errs, "; "))
+	}
+	return nil
 }
 
 // GetMounts gets mountpoints for the specified volume